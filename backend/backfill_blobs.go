@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"backend/handlers"
+)
+
+// backfillBlobs records blobs stored before deduplication in Blobs, so they
+// are deduplicated, scrubbed and collected like new ones. It is safe to run
+// more than once.
+func backfillBlobs() {
+	added, merged, skipped, err := handlers.BackfillBlobs(context.Background())
+	if err != nil {
+		log.Fatal("Ошибка заполнения таблицы блобов:", err)
+	}
+	fmt.Printf("✅ Блобов добавлено: %d, объединено с копиями: %d, пропущено: %d\n", added, merged, skipped)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"backend/config"
+	"backend/storage"
+)
+
+type checkReport struct {
+	CheckedAt   time.Time `json:"checked_at"`
+	Repair      bool      `json:"repair"`
+	Files       int       `json:"files"`
+	Versions    int       `json:"versions"`
+	Blobs       int       `json:"blobs"`        // distinct blobs referenced
+	StoredBlobs int       `json:"stored_blobs"` // blobs in storage, -1 if it cannot be listed
+	Problems    []problem `json:"problems"`
+}
+
+type problem struct {
+	Kind      string `json:"kind"`
+	Key       string `json:"key,omitempty"`
+	FileID    int    `json:"file_id,omitempty"`
+	VersionID int    `json:"version_id,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	Repaired  bool   `json:"repaired,omitempty"`
+}
+
+type blobRef struct {
+	fileID    int
+	versionID int // 0 for the Files row itself
+}
+
+type blobRecord struct {
+	sha256 string
+	size   int64
+}
+
+// check cross-checks Files and FileVersions against the blob storage and
+// prints a JSON report. With -repair it fixes the cases that cannot lose
+// data: unreferenced blobs and GridFS chunks, stale Blobs rows and files
+// whose version_id can be pointed at their own version of the same blob.
+// Exits with 1 if problems remain.
+func check(out io.Writer, args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "repair safe cases")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	report := checkReport{CheckedAt: time.Now().UTC(), Repair: *repair, StoredBlobs: -1, Problems: []problem{}}
+	add := func(p problem) { report.Problems = append(report.Problems, p) }
+
+	refs, err := loadBlobRefs(&report)
+	if err != nil {
+		log.Fatal("Ошибка чтения файлов:", err)
+	}
+	report.Blobs = len(refs)
+
+	records := map[string]blobRecord{}
+	err = eachRow("SELECT blob_key, sha256, size FROM Blobs", func(rows *sql.Rows) error {
+		var key string
+		var record blobRecord
+		if err := rows.Scan(&key, &record.sha256, &record.size); err != nil {
+			return err
+		}
+		records[key] = record
+		return nil
+	})
+	if err != nil {
+		log.Fatal("Ошибка чтения Blobs:", err)
+	}
+
+	pending := map[string]bool{}
+	err = eachRow("SELECT blob_key FROM PendingBlobs", func(rows *sql.Rows) error {
+		var key string
+		err := rows.Scan(&key)
+		pending[key] = true
+		return err
+	})
+	if err != nil {
+		log.Fatal("Ошибка чтения PendingBlobs:", err)
+	}
+
+	// every referenced or recorded blob must be readable and match its checksum
+	var keys []string
+	for key := range refs {
+		keys = append(keys, key)
+	}
+	for key := range records {
+		if _, ok := refs[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		_, err := config.Blobs.Stat(ctx, key)
+		if err == storage.ErrNotFound {
+			if len(refs[key]) == 0 {
+				p := problem{Kind: "stale_blob_record", Key: key, Detail: "Blobs row without a stored blob or references"}
+				if *repair {
+					p.Repaired = deleteBlobRecord(key) == nil
+				}
+				add(p)
+			}
+			for _, ref := range refs[key] {
+				add(problem{Kind: "missing_blob", Key: key, FileID: ref.fileID, VersionID: ref.versionID})
+			}
+			continue
+		} else if err != nil {
+			add(problem{Kind: "unreadable_blob", Key: key, Detail: err.Error()})
+			continue
+		}
+
+		if record, ok := records[key]; ok {
+			if p, ok := verifyChecksum(ctx, key, record); !ok {
+				add(p)
+			}
+		} else {
+			// stored before Blobs, neither deduplicated nor scrubbed
+			add(problem{Kind: "untracked_blob", Key: key, Detail: "no Blobs row, run backfill-blobs"})
+		}
+	}
+
+	// stored blobs nothing refers to
+	base := storage.Base(config.Blobs)
+	if lister, ok := base.(storage.Lister); ok {
+		known := map[string]bool{}
+		for key := range refs {
+			known[storage.BaseKey(key)] = true
+		}
+		for key := range pending {
+			known[storage.BaseKey(key)] = true
+		}
+		tracked := map[string]string{} // base key -> key in Blobs
+		for key := range records {
+			tracked[storage.BaseKey(key)] = key
+		}
+
+		report.StoredBlobs = 0
+		err := lister.List(ctx, func(info storage.BlobInfo) error {
+			report.StoredBlobs++
+			if known[info.Key] {
+				return nil
+			}
+			p := problem{Kind: "orphaned_blob", Key: info.Key, Detail: fmt.Sprintf("%d bytes, stored %s", info.Size, info.UploadDate.UTC().Format(time.RFC3339))}
+			if key, ok := tracked[info.Key]; ok {
+				// storeBlob may reuse a recorded blob, so only the collector deletes it
+				if *repair && queueOrphan(key) == nil {
+					p.Repaired = true
+					p.Detail += ", queued for the orphan collector"
+				}
+			} else if *repair && time.Since(info.UploadDate) > config.OrphanGracePeriod {
+				p.Repaired = base.Delete(ctx, info.Key) == nil
+			}
+			add(p)
+			return nil
+		})
+		if err != nil {
+			log.Fatal("Ошибка чтения хранилища:", err)
+		}
+	}
+
+	if checker, ok := base.(storage.Checker); ok {
+		problems, err := checker.Check(ctx, *repair)
+		if err != nil {
+			log.Fatal("Ошибка проверки хранилища:", err)
+		}
+		for _, p := range problems {
+			add(problem{Kind: p.Kind, Key: p.Key, Detail: p.Detail, Repaired: p.Repaired})
+		}
+	}
+
+	if err := checkCurrentVersions(*repair, add); err != nil {
+		log.Fatal("Ошибка проверки версий:", err)
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+
+	for _, p := range report.Problems {
+		if !p.Repaired {
+			os.Exit(1)
+		}
+	}
+}
+
+// loadBlobRefs maps every blob key to the files and versions pointing to it
+func loadBlobRefs(report *checkReport) (map[string][]blobRef, error) {
+	refs := map[string][]blobRef{}
+	err := eachRow("SELECT file_id, mongo_file_id FROM Files", func(rows *sql.Rows) error {
+		var ref blobRef
+		var key sql.NullString
+		if err := rows.Scan(&ref.fileID, &key); err != nil {
+			return err
+		}
+		report.Files++
+		if key.Valid {
+			refs[key.String] = append(refs[key.String], ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = eachRow("SELECT file_id, version_id, mongo_file_id FROM FileVersions", func(rows *sql.Rows) error {
+		var ref blobRef
+		var fileID sql.NullInt64
+		var key sql.NullString
+		if err := rows.Scan(&fileID, &ref.versionID, &key); err != nil {
+			return err
+		}
+		report.Versions++
+		ref.fileID = int(fileID.Int64)
+		if key.Valid {
+			refs[key.String] = append(refs[key.String], ref)
+		}
+		return nil
+	})
+	return refs, err
+}
+
+func verifyChecksum(ctx context.Context, key string, record blobRecord) (problem, bool) {
+	rc, err := config.Blobs.Get(ctx, key)
+	if err != nil {
+		return problem{Kind: "unreadable_blob", Key: key, Detail: err.Error()}, false
+	}
+	defer rc.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, rc)
+	if err != nil {
+		return problem{Kind: "unreadable_blob", Key: key, Detail: err.Error()}, false
+	}
+	if size != record.size {
+		return problem{Kind: "size_mismatch", Key: key, Detail: fmt.Sprintf("expected %d bytes, read %d", record.size, size)}, false
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != record.sha256 {
+		return problem{Kind: "checksum_mismatch", Key: key, Detail: "expected sha256 " + record.sha256 + ", read " + sum}, false
+	}
+	return problem{}, true
+}
+
+// checkCurrentVersions finds files whose version_id is empty or belongs to
+// another file. The safe repair is pointing it at the file's own latest
+// version with the same blob.
+func checkCurrentVersions(repair bool, add func(problem)) error {
+	type badFile struct {
+		fileID    int
+		key       sql.NullString
+		versionID sql.NullInt64
+		owner     sql.NullInt64
+	}
+	var files []badFile
+	err := eachRow(`
+		SELECT f.file_id, f.mongo_file_id, f.version_id, v.file_id
+		FROM Files f
+		LEFT JOIN FileVersions v ON v.version_id = f.version_id
+		WHERE v.version_id IS NULL OR v.file_id IS NULL OR v.file_id <> f.file_id
+	`, func(rows *sql.Rows) error {
+		var f badFile
+		if err := rows.Scan(&f.fileID, &f.key, &f.versionID, &f.owner); err != nil {
+			return err
+		}
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		p := problem{Kind: "missing_version", Key: f.key.String, FileID: f.fileID}
+		if f.owner.Valid {
+			p.Kind = "foreign_version"
+			p.VersionID = int(f.versionID.Int64)
+			p.Detail = fmt.Sprintf("version belongs to file %d", f.owner.Int64)
+		}
+
+		if repair && f.key.Valid {
+			var versionID int
+			err := config.PostgresDB.QueryRow(`
+				SELECT version_id FROM FileVersions
+				WHERE file_id = $1 AND mongo_file_id = $2
+				ORDER BY create_date DESC, version_id DESC
+				LIMIT 1
+			`, f.fileID, f.key.String).Scan(&versionID)
+			if err == nil {
+				_, err = config.PostgresDB.Exec("UPDATE Files SET version_id = $1 WHERE file_id = $2", versionID, f.fileID)
+				p.Repaired = err == nil
+			} else if err != sql.ErrNoRows {
+				return err
+			}
+		}
+		add(p)
+	}
+	return nil
+}
+
+func deleteBlobRecord(key string) error {
+	_, err := config.PostgresDB.Exec("DELETE FROM PendingBlobs WHERE blob_key = $1", key)
+	if err != nil {
+		return err
+	}
+	_, err = config.PostgresDB.Exec("DELETE FROM Blobs WHERE blob_key = $1", key)
+	return err
+}
+
+func queueOrphan(key string) error {
+	_, err := config.PostgresDB.Exec(`
+		INSERT INTO PendingBlobs (blob_key, create_date)
+		VALUES ($1, $2)
+		ON CONFLICT (blob_key) DO NOTHING
+	`, key, time.Now().UTC())
+	return err
+}
+
+func eachRow(query string, fn func(rows *sql.Rows) error) error {
+	rows, err := config.PostgresDB.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+
+	"backend/storage"
+)
+
+// blobKeyStore keeps the wrapped data keys of encrypted blobs in BlobKeys
+type blobKeyStore struct{}
+
+func (blobKeyStore) SaveKey(ctx context.Context, blobKey, wrapped, masterKeyID string) error {
+	_, err := PostgresDB.ExecContext(ctx, `
+		INSERT INTO BlobKeys (blob_key, data_key, master_key_id)
+		VALUES ($1, $2, $3)
+	`, blobKey, wrapped, masterKeyID)
+	return err
+}
+
+func (blobKeyStore) LoadKey(ctx context.Context, blobKey string) (string, string, error) {
+	var wrapped, masterKeyID string
+	err := PostgresDB.QueryRowContext(ctx, `
+		SELECT data_key, master_key_id FROM BlobKeys WHERE blob_key = $1
+	`, blobKey).Scan(&wrapped, &masterKeyID)
+	if err == sql.ErrNoRows {
+		return "", "", storage.ErrNotFound
+	}
+	return wrapped, masterKeyID, err
+}
+
+func (blobKeyStore) DeleteKey(ctx context.Context, blobKey string) error {
+	_, err := PostgresDB.ExecContext(ctx, "DELETE FROM BlobKeys WHERE blob_key = $1", blobKey)
+	return err
+}
@@ -10,14 +10,29 @@ import (
 	_ "github.com/lib/pq"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"backend/storage"
 )
 
-var DB *mongo.Database // Mongo
-var PostgresDB *sql.DB // PostgreSQL
+var DB *mongo.Database       // Mongo
+var PostgresDB *sql.DB       // PostgreSQL, or SQLite in embedded mode
+var Blobs storage.BlobStore  // file contents
+var Keyring *storage.Keyring // master keys, nil without encryption
 
+// ConnectDB opens the metadata database and the blob storage.
+// DB_BACKEND=sqlite runs in embedded mode: metadata in a SQLite file and,
+// unless STORAGE_BACKEND says otherwise, blobs on local disk.
 func ConnectDB() {
-	connectMongo()
-	connectPostgres()
+	if Embedded() {
+		connectSQLite()
+	} else {
+		connectPostgres()
+	}
+	connectStorage()
+}
+
+func Embedded() bool {
+	return os.Getenv("DB_BACKEND") == "sqlite"
 }
 
 func connectMongo() {
@@ -81,3 +96,68 @@ func connectPostgres() {
 
 	PostgresDB = db
 }
+
+func connectStorage() {
+	backend := os.Getenv("STORAGE_BACKEND")
+	if backend == "" && Embedded() {
+		backend = "local"
+	} else if backend == "" {
+		backend = "gridfs"
+	}
+
+	switch backend {
+	case "gridfs":
+		connectMongo()
+		Blobs = storage.NewGridFSStore(DB)
+	case "local":
+		path := os.Getenv("STORAGE_PATH")
+		if path == "" {
+			path = "./data/blobs"
+		}
+		store, err := storage.NewLocalStore(path)
+		if err != nil {
+			log.Fatal("Ошибка инициализации локального хранилища:", err)
+		}
+		Blobs = store
+	case "s3":
+		store, err := storage.NewS3Store(context.TODO(), storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+		if err != nil {
+			log.Fatal("Ошибка подключения к S3:", err)
+		}
+		Blobs = store
+	default:
+		log.Fatal("Неизвестный STORAGE_BACKEND: ", backend)
+	}
+
+	if EncryptionKey != nil {
+		var previous [][]byte
+		if EncryptionPreviousKey != nil {
+			previous = append(previous, EncryptionPreviousKey)
+		}
+		ring, err := storage.NewKeyring(EncryptionKey, previous...)
+		if err != nil {
+			log.Fatal("Ошибка загрузки ключей шифрования:", err)
+		}
+		Keyring = ring
+		Blobs = storage.NewEncryptedStore(Blobs, blobKeyStore{}, ring)
+	}
+
+	// always wrapped, so compressed blobs stay readable with COMPRESSION off
+	compressed, err := storage.NewCompressedStore(Blobs, Compression, int(CompressionMinSize))
+	if err != nil {
+		log.Fatal("Некорректное значение COMPRESSION:", err)
+	}
+	Blobs = compressed
+
+	if err := os.MkdirAll(UploadPath, 0o755); err != nil {
+		log.Fatal("Ошибка создания каталога загрузок:", err)
+	}
+
+	fmt.Println("✅ Хранилище файлов:", backend)
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/base64"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxUploadSize is the upload limit in bytes for roles without their own
+// max_upload_size; 0 means no limit
+var MaxUploadSize int64
+
+// UploadPath keeps unfinished resumable uploads
+var UploadPath string
+
+// UploadExpiration is how long an unfinished resumable upload is kept
+// after its last chunk
+var UploadExpiration time.Duration
+
+// OrphanGracePeriod is how long a blob stays in the PendingBlobs outbox
+// before the collector may delete it
+var OrphanGracePeriod time.Duration
+
+// TrashRetention is how long deleted files stay in the trash before they
+// are purged; 0 keeps them until the owner empties the trash
+var TrashRetention time.Duration
+
+// ScrubInterval is how often every blob is re-read and checked against its
+// recorded checksum; 0 disables scrubbing
+var ScrubInterval time.Duration
+
+// Compression is the algorithm for new blobs: "gzip", "zstd" or "" for none
+var Compression string
+
+// CompressionMinSize is the size in bytes below which blobs are stored as is
+var CompressionMinSize int64
+
+// EncryptionKey is the master key that wraps the data keys of new blobs;
+// without it blobs are stored unencrypted. EncryptionPreviousKey is only
+// used to unwrap data keys until rotate-key moves them to the current key.
+var EncryptionKey, EncryptionPreviousKey []byte
+
+func LoadSettings() {
+	MaxUploadSize = envInt64("MAX_UPLOAD_SIZE", 0)
+
+	UploadPath = os.Getenv("UPLOAD_PATH")
+	if UploadPath == "" {
+		UploadPath = "./data/uploads"
+	}
+	UploadExpiration = time.Duration(envInt64("UPLOAD_EXPIRATION_HOURS", 24)) * time.Hour
+
+	OrphanGracePeriod = time.Duration(envInt64("ORPHAN_GRACE_MINUTES", 30)) * time.Minute
+
+	TrashRetention = time.Duration(envInt64("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour
+
+	ScrubInterval = time.Duration(envInt64("SCRUB_INTERVAL_DAYS", 30)) * 24 * time.Hour
+
+	Compression = os.Getenv("COMPRESSION")
+	if Compression == "off" {
+		Compression = ""
+	}
+	CompressionMinSize = envInt64("COMPRESSION_MIN_SIZE", 4096)
+
+	EncryptionKey = envKey("ENCRYPTION_KEY")
+	EncryptionPreviousKey = envKey("ENCRYPTION_PREVIOUS_KEY")
+	if EncryptionKey == nil && EncryptionPreviousKey != nil {
+		log.Fatal("ENCRYPTION_PREVIOUS_KEY задан без ENCRYPTION_KEY")
+	}
+}
+
+func envInt64(name string, def int64) int64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Fatalf("Некорректное значение %s: %s", name, value)
+	}
+	return n
+}
+
+// envKey reads a base64 encoded 32 byte key from NAME or from the file in NAME_FILE
+func envKey(name string) []byte {
+	value := os.Getenv(name)
+	if path := os.Getenv(name + "_FILE"); value == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Ошибка чтения %s_FILE: %v", name, err)
+		}
+		value = string(data)
+	}
+	if value == "" {
+		return nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != 32 {
+		log.Fatalf("%s должен быть 32 байтами в base64", name)
+	}
+	return key
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	_ "embed"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+//go:embed sqlite_schema.sql
+var sqliteSchema string
+
+func init() {
+	sql.Register("sqlite-pg", sqliteDriver{&sqlite.Driver{}})
+}
+
+// connectSQLite opens the embedded metadata database. Handlers keep
+// writing PostgreSQL queries, the driver below rewrites them for SQLite.
+func connectSQLite() {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = "./data/filestorage.db"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Fatal("Ошибка создания каталога SQLite:", err)
+	}
+
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate&_time_format=sqlite"
+
+	// the schema is plain SQLite, so it goes through the unwrapped driver
+	raw, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		log.Fatal("Ошибка подключения к SQLite:", err)
+	}
+	_, err = raw.Exec(sqliteSchema)
+	raw.Close()
+	if err != nil {
+		log.Fatal("Ошибка создания схемы SQLite:", err)
+	}
+
+	db, err := sql.Open("sqlite-pg", dsn)
+	if err != nil {
+		log.Fatal("Ошибка подключения к SQLite:", err)
+	}
+	if err = db.Ping(); err != nil {
+		log.Fatal("SQLite не отвечает:", err)
+	}
+
+	fmt.Println("✅ Подключение к SQLite успешно!")
+
+	PostgresDB = db
+}
+
+var (
+	pgParam     = regexp.MustCompile(`\$(\d+)`)
+	pgILike     = regexp.MustCompile(`(?i)\bILIKE\b`)
+	pgNow       = regexp.MustCompile(`(?i)\bNOW\(\)`)
+	pgForUpdate = regexp.MustCompile(`(?i)\s+FOR (NO KEY )?UPDATE\b`)
+	pgGroupTree = regexp.MustCompile(`get_group_tree\((\$\d+)\)`)
+)
+
+// same rows as the get_group_tree function in postgre/init.sql
+const sqliteGroupTree = `(
+	WITH RECURSIVE tree(group_id, name, description, parent_id, depth, path) AS (
+		SELECT group_id, name, description, parent_id, 0, printf('%010d', group_id)
+		FROM Groups
+		WHERE ($1 IS NULL AND parent_id IS NULL) OR group_id = $1
+
+		UNION ALL
+
+		SELECT g.group_id, g.name, g.description, g.parent_id, t.depth + 1, t.path || '/' || printf('%010d', g.group_id)
+		FROM Groups g
+		JOIN tree t ON g.parent_id = t.group_id
+	)
+	SELECT * FROM tree ORDER BY path
+)`
+
+func rewriteQuery(query string) string {
+	query = pgGroupTree.ReplaceAllStringFunc(query, func(m string) string {
+		param := pgGroupTree.FindStringSubmatch(m)[1]
+		return strings.ReplaceAll(sqliteGroupTree, "$1", param)
+	})
+	query = pgParam.ReplaceAllString(query, "?$1")
+	query = pgILike.ReplaceAllString(query, "LIKE")
+	query = pgNow.ReplaceAllString(query, "CURRENT_TIMESTAMP")
+	query = pgForUpdate.ReplaceAllString(query, "")
+	return query
+}
+
+type sqliteDriver struct {
+	driver.Driver
+}
+
+func (d sqliteDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteConn{conn}, nil
+}
+
+type sqliteConn struct {
+	driver.Conn
+}
+
+func (c sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rewriteQuery(query))
+}
+
+func (c sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.(driver.ConnPrepareContext).PrepareContext(ctx, rewriteQuery(query))
+}
+
+func (c sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, rewriteQuery(query), args)
+}
+
+func (c sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, rewriteQuery(query), args)
+}
+
+func (c sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c sqliteConn) ResetSession(ctx context.Context) error {
+	return c.Conn.(driver.SessionResetter).ResetSession(ctx)
+}
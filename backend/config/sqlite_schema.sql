@@ -0,0 +1,237 @@
+-- SQLite version of postgre/init.sql used in embedded mode
+
+CREATE TABLE IF NOT EXISTS Permissions (
+    permission_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100),
+    description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS Roles (
+    role_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100),
+    description TEXT,
+    max_upload_size BIGINT -- bytes, NULL uses MAX_UPLOAD_SIZE, 0 is unlimited
+);
+
+CREATE TABLE IF NOT EXISTS Role_Permissions (
+    role_id INTEGER REFERENCES Roles(role_id) ON DELETE CASCADE,
+    permission_id INTEGER REFERENCES Permissions(permission_id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS Groups (
+    group_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100),
+    description TEXT,
+    parent_id INTEGER REFERENCES Groups(group_id) ON DELETE SET NULL,
+    quota_bytes BIGINT, -- for the group and its subgroups, NULL or 0 is unlimited
+    quota_files BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS Access (
+    access_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100),
+    level INTEGER NOT NULL UNIQUE -- a level includes the capabilities of the lower ones
+);
+
+CREATE TABLE IF NOT EXISTS Users (
+    user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    role_id INTEGER REFERENCES Roles(role_id),
+    group_id INTEGER REFERENCES Groups(group_id),
+    mail VARCHAR(255),
+    login VARCHAR(100),
+    password VARCHAR(100),
+    name VARCHAR(100),
+    surname VARCHAR(100),
+    type VARCHAR(100),
+    quota_bytes BIGINT, -- NULL or 0 is unlimited
+    quota_files BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS Folders (
+    folder_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    parent_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE, -- NULL at the top level
+    name VARCHAR(255) NOT NULL,
+    full_path VARCHAR(255) NOT NULL, -- names from the top, e.g. /projects/2024
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    edit_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS folders_name ON Folders (owner_id, COALESCE(parent_id, 0), name);
+
+CREATE TABLE IF NOT EXISTS Files (
+    file_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    mongo_file_id TEXT, -- blob key in the configured storage backend
+    sha256 CHAR(64), -- of the content as uploaded
+    size BIGINT,
+    owner_id INTEGER REFERENCES Users(user_id),
+    version_id INTEGER REFERENCES FileVersions(version_id) ON DELETE SET NULL,
+    type VARCHAR(50),
+    name VARCHAR(100),
+    full_path VARCHAR(255),
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE SET NULL, -- NULL at the top level
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    edit_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    access_date TIMESTAMP, -- last download
+    deleted_at TIMESTAMP -- moved to trash, NULL if not
+);
+
+CREATE TABLE IF NOT EXISTS FileVersions (
+    version_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES Users(user_id),
+    name VARCHAR(100),
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    edit_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    mongo_file_id TEXT, -- blob key in the configured storage backend
+    sha256 CHAR(64), -- of the content as uploaded
+    size BIGINT,
+    UNIQUE(file_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS File_Users (
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    access_id INTEGER REFERENCES Access(access_id), -- NULL removes access inherited from folders
+    expires_at TIMESTAMP, -- NULL never expires
+    PRIMARY KEY (file_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS File_Groups (
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE CASCADE,
+    group_id INTEGER REFERENCES Groups(group_id) ON DELETE CASCADE,
+    access_id INTEGER REFERENCES Access(access_id), -- NULL removes access inherited from folders
+    expires_at TIMESTAMP, -- NULL never expires
+    PRIMARY KEY (file_id, group_id)
+);
+
+-- access to a folder is inherited by the folders and files below it, the
+-- nearest grant to the same user or group wins
+CREATE TABLE IF NOT EXISTS Folder_Users (
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    access_id INTEGER REFERENCES Access(access_id), -- NULL removes access inherited from parent folders
+    expires_at TIMESTAMP, -- NULL never expires
+    PRIMARY KEY (folder_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS Folder_Groups (
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    group_id INTEGER REFERENCES Groups(group_id) ON DELETE CASCADE,
+    access_id INTEGER REFERENCES Access(access_id), -- NULL removes access inherited from parent folders
+    expires_at TIMESTAMP, -- NULL never expires
+    PRIMARY KEY (folder_id, group_id)
+);
+
+CREATE TABLE IF NOT EXISTS Notifications (
+    notification_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    type VARCHAR(50), -- share_expired or file_request_upload
+    message TEXT,
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE SET NULL,
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE SET NULL,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    read_at TIMESTAMP
+);
+
+-- public links to a file or folder for people without an account
+CREATE TABLE IF NOT EXISTS ShareLinks (
+    link_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE CASCADE, -- the file or the folder
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    created_by INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    password VARCHAR(100), -- bcrypt hash, NULL if none
+    mode VARCHAR(20) NOT NULL, -- view or download
+    expires_at TIMESTAMP, -- NULL never expires
+    max_downloads INTEGER, -- NULL is unlimited
+    download_count INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMP,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- upload only links to a folder, the uploaded files belong to the creator
+CREATE TABLE IF NOT EXISTS FileRequests (
+    request_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    folder_id INTEGER NOT NULL REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    created_by INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    title VARCHAR(255),
+    max_file_size BIGINT, -- bytes, NULL uses the upload limit of the creator
+    allowed_types TEXT, -- comma separated extensions, e.g. .pdf,.docx, NULL allows any
+    deadline TIMESTAMP, -- NULL never closes
+    revoked_at TIMESTAMP,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS FileRequestUploads (
+    file_id INTEGER PRIMARY KEY REFERENCES Files(file_id) ON DELETE CASCADE,
+    request_id INTEGER REFERENCES FileRequests(request_id) ON DELETE SET NULL,
+    uploader_name VARCHAR(100),
+    uploader_mail VARCHAR(255),
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS Blobs (
+    blob_key TEXT PRIMARY KEY,
+    sha256 CHAR(64) UNIQUE,
+    size BIGINT,
+    stored_size BIGINT,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    verified_at TIMESTAMP, -- last integrity scrub
+    last_attempt_at TIMESTAMP, -- last scrub, also one that could not read the blob
+    verify_error TEXT -- NULL if the last scrub read the content intact
+);
+
+CREATE TABLE IF NOT EXISTS PendingBlobs (
+    blob_key TEXT PRIMARY KEY, -- blob that may have no references yet or anymore
+    create_date TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS BlobKeys (
+    blob_key TEXT PRIMARY KEY, -- key of the encrypted blob, without the compression prefix
+    data_key TEXT NOT NULL, -- wrapped with the master key master_key_id
+    master_key_id VARCHAR(16) NOT NULL,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS Uploads (
+    upload_id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    upload_length BIGINT,
+    upload_offset BIGINT DEFAULT 0,
+    name VARCHAR(100),
+    full_path VARCHAR(255),
+    type VARCHAR(50),
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE SET NULL,
+    expires_at TIMESTAMP,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO Permissions (name, description)
+SELECT * FROM (VALUES
+    ('manage_roles', 'Управление ролями'),
+    ('manage_groups', 'Управление группами'),
+    ('manage_users', 'Управление пользователями'))
+WHERE NOT EXISTS (SELECT 1 FROM Permissions);
+
+INSERT INTO Permissions (name, description)
+SELECT 'view_reports', 'Просмотр отчетов'
+WHERE NOT EXISTS (SELECT 1 FROM Permissions WHERE name = 'view_reports');
+
+-- access_id 1 to 3 take over from the former read, write and manage
+INSERT INTO Access (name, level)
+SELECT * FROM (VALUES
+    ('download', 20),
+    ('edit', 40),
+    ('reshare', 60),
+    ('view', 10),
+    ('comment', 30),
+    ('manage_versions', 50),
+    ('full_control', 70))
+WHERE NOT EXISTS (SELECT 1 FROM Access);
+
+INSERT INTO Users (login, password, mail, name, surname, type)
+SELECT 'admin', '$2a$10$FMCEflfMWM0mdyj2laQLmOZ6KbpVH5.I62Hj7wPCzZmYWxYFbCtqG', 'admin@admin.admin', 'admin', 'admin', 'admin'
+WHERE NOT EXISTS (SELECT 1 FROM Users);
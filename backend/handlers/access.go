@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+)
+
+// Access levels of a user on a file or folder, the level column of the
+// Access rows. Each level includes the capabilities of the ones below it:
+//   - view: see the file, its metadata and versions, but not the content
+//   - download: read the content and copy the file
+//   - comment: reserved for comments on the file
+//   - edit: change the content and name
+//   - manage_versions: create, rename, delete and switch versions
+//   - reshare: view and change the shares, up to the caller's own level
+//   - full_control: move the file and move it to the trash
+//
+// The owner and admins have full control.
+const (
+	accessNone           = 0
+	accessView           = 10
+	accessDownload       = 20
+	accessComment        = 30
+	accessEdit           = 40
+	accessManageVersions = 50
+	accessReshare        = 60
+	accessFullControl    = 70
+)
+
+// accessLevel is a level with the name of its Access row
+type accessLevel struct {
+	Level int
+	Name  string
+}
+
+// fileAuth is the caller's access on a file
+type fileAuth struct {
+	FileID  int
+	OwnerID int
+	Level   int
+}
+
+// fileAccess returns the access level of a user on a file that is not in
+// the trash, sql.ErrNoRows if there is no such file
+func fileAccess(q queryer, fileID, userID int) (fileAuth, error) {
+	auth := fileAuth{FileID: fileID}
+	err := q.QueryRow("SELECT owner_id FROM Files WHERE file_id = $1 AND deleted_at IS NULL", fileID).Scan(&auth.OwnerID)
+	if err != nil {
+		return auth, err
+	}
+	if auth.OwnerID == userID {
+		auth.Level = accessFullControl
+		return auth, nil
+	}
+
+	admin, err := isAdmin(q, userID)
+	if err != nil || admin {
+		auth.Level = accessFullControl
+		return auth, err
+	}
+
+	access, _, err := userAccess(q, fileID, userID)
+	auth.Level = access.Level
+	return auth, err
+}
+
+func isAdmin(q queryer, userID int) (bool, error) {
+	var userType sql.NullString
+	err := q.QueryRow("SELECT type FROM Users WHERE user_id = $1", userID).Scan(&userType)
+	return userType.String == "admin", err
+}
+
+// authorizeFile checks that the caller has at least level on the file in
+// the {file_id} route variable. A file the caller has no access to is
+// answered as not found.
+func authorizeFile(w http.ResponseWriter, r *http.Request, level int) (fileAuth, bool) {
+	fileID, err := strconv.Atoi(mux.Vars(r)["file_id"])
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return fileAuth{}, false
+	}
+	return authorizeFileID(w, r, fileID, level)
+}
+
+// authorizeFileID is authorizeFile for a file_id found otherwise
+func authorizeFileID(w http.ResponseWriter, r *http.Request, fileID int, level int) (fileAuth, bool) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return fileAuth{}, false
+	}
+
+	auth, err := fileAccess(config.PostgresDB, fileID, userID)
+	if err == sql.ErrNoRows || (err == nil && auth.Level == accessNone) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return fileAuth{}, false
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return fileAuth{}, false
+	}
+	if auth.Level < level {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return fileAuth{}, false
+	}
+	return auth, true
+}
+
+// Access is granted on files and on folders, a folder grant is inherited by
+// the folders and files below it. For each user or group the nearest grant
+// wins: the file's own, then the one of the closest folder above it. A grant
+// without access_id removes inherited access. A user gets the highest access
+// of their own and their group's winning grants. Expired grants are ignored
+// until PurgeExpiredShares removes them.
+
+// fileGrants returns the grants on a file and on the folders above it,
+// nearest first
+func fileGrants(q queryer, fileID int) ([]models.AccessGrant, error) {
+	var folderID *int
+	if err := q.QueryRow("SELECT folder_id FROM Files WHERE file_id = $1", fileID).Scan(&folderID); err != nil {
+		return nil, err
+	}
+
+	grants, err := loadGrants(q, "File", "file_id", fileID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range grants {
+		grants[i].Source = "file"
+	}
+
+	inherited, err := folderGrants(q, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return markOverridden(append(grants, inherited...)), nil
+}
+
+// folderGrants returns the grants on a folder and its parents, nearest
+// first, without marking overridden ones
+func folderGrants(q queryer, folderID *int) ([]models.AccessGrant, error) {
+	var grants []models.AccessGrant
+	seen := map[int]bool{}
+	for id := folderID; id != nil && !seen[*id]; {
+		seen[*id] = true
+		var parentID *int
+		var path string
+		err := q.QueryRow("SELECT parent_id, full_path FROM Folders WHERE folder_id = $1", *id).Scan(&parentID, &path)
+		if err != nil {
+			return nil, err
+		}
+
+		folder, err := loadGrants(q, "Folder", "folder_id", *id)
+		if err != nil {
+			return nil, err
+		}
+		for i := range folder {
+			folder[i].Source = "folder"
+			folder[i].FolderID = id
+			folder[i].FolderPath = path
+		}
+		grants = append(grants, folder...)
+		id = parentID
+	}
+	return grants, nil
+}
+
+// loadGrants reads the File_ or Folder_ Users and Groups rows of one item,
+// except expired ones
+func loadGrants(q queryer, table, column string, id int) ([]models.AccessGrant, error) {
+	var grants []models.AccessGrant
+	for _, principal := range []string{"user_id", "group_id"} {
+		from := table + "_Users"
+		if principal == "group_id" {
+			from = table + "_Groups"
+		}
+		rows, err := q.Query(`
+			SELECT g.`+principal+`, g.access_id, a.name, a.level, g.expires_at
+			FROM `+from+` g
+			LEFT JOIN Access a ON a.access_id = g.access_id
+			WHERE g.`+column+` = $1 AND (g.expires_at IS NULL OR g.expires_at > $2)
+			ORDER BY g.`+principal, id, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var grant models.AccessGrant
+			var principalID int
+			var name sql.NullString
+			var level sql.NullInt64
+			if err := rows.Scan(&principalID, &grant.AccessID, &name, &level, &grant.ExpiresAt); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			grant.Access, grant.Level = name.String, int(level.Int64)
+			if principal == "user_id" {
+				grant.UserID = &principalID
+			} else {
+				grant.GroupID = &principalID
+			}
+			grants = append(grants, grant)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return grants, nil
+}
+
+// markOverridden flags every grant after the first one to the same user or
+// group
+func markOverridden(grants []models.AccessGrant) []models.AccessGrant {
+	seenUsers := map[int]bool{}
+	seenGroups := map[int]bool{}
+	for i, grant := range grants {
+		if grant.UserID != nil {
+			grants[i].Overridden = seenUsers[*grant.UserID]
+			seenUsers[*grant.UserID] = true
+		} else {
+			grants[i].Overridden = seenGroups[*grant.GroupID]
+			seenGroups[*grant.GroupID] = true
+		}
+	}
+	return grants
+}
+
+// userAccess returns the effective access of a user on a file, level 0 for
+// none, and the groups it comes from if given through the user's group
+func userAccess(q queryer, fileID, userID int) (accessLevel, []int, error) {
+	grants, err := fileGrants(q, fileID)
+	if err != nil {
+		return accessLevel{}, nil, err
+	}
+	return effectiveAccess(q, grants, userID)
+}
+
+// effectiveAccess is userAccess for grants with the overridden ones marked
+func effectiveAccess(q queryer, grants []models.AccessGrant, userID int) (accessLevel, []int, error) {
+	var groupID sql.NullInt64
+	if err := q.QueryRow("SELECT group_id FROM Users WHERE user_id = $1", userID).Scan(&groupID); err != nil {
+		return accessLevel{}, nil, err
+	}
+
+	var access accessLevel
+	var groupIDs []int
+	for _, grant := range grants {
+		if grant.Overridden || grant.AccessID == nil {
+			continue
+		}
+		mine := grant.UserID != nil && *grant.UserID == userID
+		if grant.GroupID != nil && groupID.Valid && int64(*grant.GroupID) == groupID.Int64 {
+			groupIDs = append(groupIDs, *grant.GroupID)
+			mine = true
+		}
+		if mine && grant.Level > access.Level {
+			access = accessLevel{grant.Level, grant.Access}
+		}
+	}
+	return access, groupIDs, nil
+}
+
+// folderAuth is a folder with the caller's access level on it
+type folderAuth struct {
+	models.Folder
+	Level int
+}
+
+// authorizeFolder loads the folder in the {folder_id} route variable and
+// checks that the caller has at least level on it, like authorizeFile
+func authorizeFolder(w http.ResponseWriter, r *http.Request, level int) (folderAuth, bool) {
+	folderID, err := strconv.Atoi(mux.Vars(r)["folder_id"])
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return folderAuth{}, false
+	}
+	return authorizeFolderID(w, r, folderID, level)
+}
+
+// authorizeFolderID is authorizeFolder for a folder_id found otherwise
+func authorizeFolderID(w http.ResponseWriter, r *http.Request, folderID int, level int) (folderAuth, bool) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return folderAuth{}, false
+	}
+
+	var auth folderAuth
+	var err error
+	auth.Folder, err = scanFolder(config.PostgresDB.QueryRow(`SELECT `+folderColumns+` FROM Folders WHERE folder_id = $1`, folderID))
+	if err == nil {
+		auth.Level, err = folderAccess(config.PostgresDB, auth.Folder, userID)
+	}
+	if err == sql.ErrNoRows || (err == nil && auth.Level == accessNone) {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return folderAuth{}, false
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return folderAuth{}, false
+	}
+	if auth.Level < level {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return folderAuth{}, false
+	}
+	return auth, true
+}
+
+// folderAccess returns the access level of a user on a folder
+func folderAccess(q queryer, folder models.Folder, userID int) (int, error) {
+	if folder.OwnerID == userID {
+		return accessFullControl, nil
+	}
+	admin, err := isAdmin(q, userID)
+	if err != nil || admin {
+		return accessFullControl, err
+	}
+
+	grants, err := folderGrants(q, &folder.FolderID)
+	if err != nil {
+		return 0, err
+	}
+	access, _, err := effectiveAccess(q, markOverridden(grants), userID)
+	return access.Level, err
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"math"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/storage"
+)
+
+// Blobs are content addressed: the Blobs table maps a SHA-256 to the one
+// stored copy of that content. Files and FileVersions rows pointing to the
+// same blob key are its references.
+//
+// Blob storage and the database cannot share a transaction, so blobs that
+// may have no references are kept in the PendingBlobs outbox: a new blob
+// until the transaction creating its file commits, and a blob whose
+// reference was removed, in the same transaction that removed it.
+// CollectOrphanBlobs deletes pending blobs that are still unreferenced after
+// config.OrphanGracePeriod. A crash between writing a blob and recording it
+// in Blobs and PendingBlobs can still leave an untracked blob behind.
+
+type storedBlob struct {
+	Key        string
+	SHA256     string
+	Size       int64
+	StoredSize int64
+}
+
+// storeBlob writes r to the blob store, or reuses the existing copy if the
+// same content is already stored. The blob stays pending until claimBlob.
+func storeBlob(ctx context.Context, name string, r io.Reader) (storedBlob, error) {
+	hash := sha256.New()
+	info, err := config.Blobs.Put(ctx, name, io.TeeReader(r, hash))
+	if err != nil {
+		return storedBlob{}, err
+	}
+	blob := storedBlob{
+		Key:        info.Key,
+		SHA256:     hex.EncodeToString(hash.Sum(nil)),
+		Size:       info.Size,
+		StoredSize: info.StoredSize,
+	}
+
+	// the copy found by hash may be deleted by the collector between the
+	// insert and the select, then the insert is tried again
+	for attempt := 0; ; attempt++ {
+		err = withTx(func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				INSERT INTO Blobs (blob_key, sha256, size, stored_size)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT (sha256) DO NOTHING
+			`, info.Key, blob.SHA256, blob.Size, info.StoredSize)
+			if err != nil {
+				return err
+			}
+
+			err = tx.QueryRow(`
+				SELECT blob_key, COALESCE(stored_size, size) FROM Blobs WHERE sha256 = $1 FOR UPDATE
+			`, blob.SHA256).Scan(&blob.Key, &blob.StoredSize)
+			if err != nil {
+				return err
+			}
+			return releaseBlob(tx, blob.Key)
+		})
+		if err != sql.ErrNoRows || attempt == 2 {
+			break
+		}
+	}
+	if err != nil {
+		config.Blobs.Delete(ctx, info.Key)
+		return storedBlob{}, err
+	}
+
+	// duplicate content, keep the copy stored before
+	if blob.Key != info.Key {
+		if err := config.Blobs.Delete(ctx, info.Key); err != nil {
+			return storedBlob{}, err
+		}
+	}
+
+	return blob, nil
+}
+
+// releaseBlob puts a blob into the outbox, to be deleted by the collector
+// unless something refers to it again within the grace period
+func releaseBlob(tx *sql.Tx, blobKey string) error {
+	_, err := tx.Exec(`
+		INSERT INTO PendingBlobs (blob_key, create_date)
+		VALUES ($1, $2)
+		ON CONFLICT (blob_key) DO UPDATE SET create_date = $2
+	`, blobKey, time.Now().UTC())
+	return err
+}
+
+// claimBlob takes a blob out of the outbox once a file or version refers to it
+func claimBlob(tx *sql.Tx, blobKey string) error {
+	_, err := tx.Exec("DELETE FROM PendingBlobs WHERE blob_key = $1", blobKey)
+	return err
+}
+
+// CollectOrphanBlobs deletes the pending blobs no file or version refers to
+func CollectOrphanBlobs() error {
+	cutoff := time.Now().UTC().Add(-config.OrphanGracePeriod)
+
+	// blobs left without references and outside the outbox are collected too
+	_, err := config.PostgresDB.Exec(`
+		INSERT INTO PendingBlobs (blob_key, create_date)
+		SELECT b.blob_key, $1 FROM Blobs b
+		WHERE NOT EXISTS (SELECT 1 FROM Files f WHERE f.mongo_file_id = b.blob_key)
+			AND NOT EXISTS (SELECT 1 FROM FileVersions v WHERE v.mongo_file_id = b.blob_key)
+		ON CONFLICT (blob_key) DO NOTHING
+	`, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	rows, err := config.PostgresDB.Query(`
+		SELECT blob_key FROM PendingBlobs WHERE create_date < $1
+	`, cutoff)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+
+	for _, key := range keys {
+		if err := collectBlob(key, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func collectBlob(blobKey string, cutoff time.Time) error {
+	var unused bool
+	err := withTx(func(tx *sql.Tx) error {
+		// storeBlob waits on this lock before reusing the blob
+		var key string
+		err := tx.QueryRow("SELECT blob_key FROM Blobs WHERE blob_key = $1 FOR UPDATE", blobKey).Scan(&key)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		// released again since the collector started
+		var stillPending bool
+		err = tx.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM PendingBlobs WHERE blob_key = $1 AND create_date < $2)
+		`, blobKey, cutoff).Scan(&stillPending)
+		if err != nil || !stillPending {
+			return err
+		}
+
+		var count int
+		err = tx.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM Files WHERE mongo_file_id = $1)
+				+ (SELECT COUNT(*) FROM FileVersions WHERE mongo_file_id = $1)
+		`, blobKey).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return claimBlob(tx, blobKey)
+		}
+
+		unused = true
+		_, err = tx.Exec("DELETE FROM Blobs WHERE blob_key = $1", blobKey)
+		return err
+	})
+	if err != nil || !unused {
+		return err
+	}
+
+	// the outbox row stays until the blob is really gone, so a failed
+	// delete is retried on the next run
+	err = config.Blobs.Delete(context.Background(), blobKey)
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+	_, err = config.PostgresDB.Exec("DELETE FROM PendingBlobs WHERE blob_key = $1", blobKey)
+	return err
+}
+
+// BackfillBlobs adds the Blobs rows of blobs stored before blobs were
+// content addressed. Each one is read to hash it, the hash is taken as
+// correct, so later scrubs only catch damage from then on. A blob with the
+// same content as one already in Blobs has its references moved to that
+// copy and is left to the collector. Files and versions without a recorded
+// sha256 or size get those of their blob. Blobs that cannot be read are
+// skipped and reported. It can be run again and returns the number of blobs
+// added, merged into another copy and skipped.
+func BackfillBlobs(ctx context.Context) (added, merged, skipped int, err error) {
+	rows, err := config.PostgresDB.Query(`
+		SELECT mongo_file_id FROM Files WHERE mongo_file_id IS NOT NULL
+		UNION
+		SELECT mongo_file_id FROM FileVersions WHERE mongo_file_id IS NOT NULL
+	`)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, 0, 0, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, 0, err
+	}
+
+	for _, key := range keys {
+		var known bool
+		err := config.PostgresDB.QueryRow("SELECT EXISTS (SELECT 1 FROM Blobs WHERE blob_key = $1)", key).Scan(&known)
+		if err != nil {
+			return added, merged, skipped, err
+		}
+		if known {
+			continue
+		}
+
+		blob, err := hashBlob(ctx, key)
+		if err != nil {
+			log.Printf("⚠️ Не удалось прочитать блоб %s, пропущен: %v", key, err)
+			skipped++
+			continue
+		}
+
+		var copyKey string
+		err = withTx(func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				INSERT INTO Blobs (blob_key, sha256, size, stored_size)
+				VALUES ($1, $2, $3, $4)
+				ON CONFLICT DO NOTHING
+			`, key, blob.SHA256, blob.Size, blob.StoredSize)
+			if err != nil {
+				return err
+			}
+			err = tx.QueryRow("SELECT blob_key FROM Blobs WHERE sha256 = $1 FOR UPDATE", blob.SHA256).Scan(&copyKey)
+			if err != nil {
+				return err
+			}
+
+			for _, table := range []string{"Files", "FileVersions"} {
+				_, err := tx.Exec(`
+					UPDATE `+table+` SET mongo_file_id = $1, sha256 = COALESCE(sha256, $2), size = COALESCE(size, $3)
+					WHERE mongo_file_id = $4
+				`, copyKey, blob.SHA256, blob.Size, key)
+				if err != nil {
+					return err
+				}
+			}
+			if copyKey != key {
+				return releaseBlob(tx, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return added, merged, skipped, err
+		}
+		if copyKey == key {
+			added++
+		} else {
+			merged++
+		}
+	}
+	return added, merged, skipped, nil
+}
+
+// hashBlob reads a stored blob and returns its checksum and sizes
+func hashBlob(ctx context.Context, key string) (storedBlob, error) {
+	stat, err := config.Blobs.Stat(ctx, key)
+	if err != nil {
+		return storedBlob{}, err
+	}
+	rc, err := config.Blobs.Get(ctx, key)
+	if err != nil {
+		return storedBlob{}, err
+	}
+	defer rc.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, rc)
+	if err != nil {
+		return storedBlob{}, err
+	}
+	return storedBlob{Key: key, SHA256: hex.EncodeToString(hash.Sum(nil)), Size: size, StoredSize: stat.StoredSize}, nil
+}
+
+// blobColumns scans sha256, size, stored_size, verified_at and verify_error
+// of a file or version joined with its Blobs row
+type blobColumns struct {
+	sha256      sql.NullString
+	size        sql.NullInt64
+	storedSize  sql.NullInt64
+	verifiedAt  sql.NullString
+	verifyError sql.NullString
+}
+
+func (c *blobColumns) dest() []interface{} {
+	return []interface{}{&c.sha256, &c.size, &c.storedSize, &c.verifiedAt, &c.verifyError}
+}
+
+func (c *blobColumns) metadata() models.BlobMetadata {
+	var m models.BlobMetadata
+	if c.sha256.Valid {
+		m.SHA256 = &c.sha256.String
+	}
+	if c.size.Valid {
+		m.Size = &c.size.Int64
+	}
+	if c.storedSize.Valid {
+		m.StoredSize = &c.storedSize.Int64
+	}
+	if c.size.Valid && c.storedSize.Valid && c.storedSize.Int64 > 0 {
+		ratio := math.Round(float64(c.size.Int64)/float64(c.storedSize.Int64)*100) / 100
+		m.CompressionRatio = &ratio
+	}
+	if c.verifiedAt.Valid {
+		m.VerifiedAt = &c.verifiedAt.String
+	}
+	m.Corrupted = c.verifyError.Valid
+	return m
+}
@@ -0,0 +1,141 @@
+package handlers_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/handlers"
+	"backend/storage"
+)
+
+// legacyBlobFile inserts a file whose blob was stored before Blobs existed
+func legacyBlobFile(t *testing.T, owner user, key string) int {
+	t.Helper()
+	var fileID int
+	err := config.PostgresDB.QueryRow(`
+		INSERT INTO Files (owner_id, mongo_file_id, name, type, full_path) VALUES ($1, $2, 'old.txt', 'text/plain', '')
+		RETURNING file_id
+	`, owner.id, key).Scan(&fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileID
+}
+
+// blobKey returns the blob a file refers to
+func blobKey(t *testing.T, fileID int) string {
+	t.Helper()
+	var key string
+	if err := config.PostgresDB.QueryRow("SELECT mongo_file_id FROM Files WHERE file_id = $1", fileID).Scan(&key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// collectPastGrace runs the collector as if the blob had been pending for
+// longer than the grace period
+func collectPastGrace(t *testing.T, key string) {
+	t.Helper()
+	_, err := config.PostgresDB.Exec("UPDATE PendingBlobs SET create_date = $1 WHERE blob_key = $2",
+		time.Now().UTC().Add(-config.OrphanGracePeriod-time.Minute), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handlers.CollectOrphanBlobs(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDedupAndCollect(t *testing.T) {
+	alice, bob := newUser(t), newUser(t)
+	firstID := uploadFile(t, alice, "a.txt", "the same bytes")
+	secondID := uploadFile(t, bob, "b.txt", "the same bytes")
+	key := blobKey(t, firstID)
+	if blobKey(t, secondID) != key {
+		t.Fatalf("the same content stored twice")
+	}
+
+	// a purged file leaves the blob to the file still using it
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/files/%d", firstID), alice.token, nil, nil), http.StatusOK)
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/trash/%d", firstID), alice.token, nil, nil), http.StatusOK)
+	collectPastGrace(t, key)
+	if body := readBody(t, request(t, "GET", fmt.Sprintf("/api/files/%d", secondID), bob.token, nil, nil), http.StatusOK); body != "the same bytes" {
+		t.Fatalf("body after collection = %q", body)
+	}
+
+	// within the grace period an unused blob is kept
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/files/%d", secondID), bob.token, nil, nil), http.StatusOK)
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/trash/%d", secondID), bob.token, nil, nil), http.StatusOK)
+	if err := handlers.CollectOrphanBlobs(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Blobs.Stat(context.Background(), key); err != nil {
+		t.Fatalf("blob collected within the grace period: %v", err)
+	}
+
+	collectPastGrace(t, key)
+	if _, err := config.Blobs.Stat(context.Background(), key); err != storage.ErrNotFound {
+		t.Errorf("unused blob still stored: %v", err)
+	}
+	var blobs int
+	config.PostgresDB.QueryRow("SELECT COUNT(*) FROM Blobs WHERE blob_key = $1", key).Scan(&blobs)
+	if blobs != 0 {
+		t.Errorf("Blobs row of a collected blob left")
+	}
+}
+
+func TestBackfillBlobs(t *testing.T) {
+	alice := newUser(t)
+	ctx := context.Background()
+
+	// two copies of the same content and a blob lost from the storage
+	var fileIDs []int
+	for i := 0; i < 2; i++ {
+		info, err := config.Blobs.Put(ctx, "old.txt", strings.NewReader("stored long ago"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fileIDs = append(fileIDs, legacyBlobFile(t, alice, info.Key))
+	}
+	legacyBlobFile(t, alice, "lost-blob-key")
+
+	added, merged, skipped, err := handlers.BackfillBlobs(ctx)
+	if err != nil || added != 1 || merged != 1 || skipped != 1 {
+		t.Fatalf("BackfillBlobs = %d, %d, %d, %v, want 1, 1, 1", added, merged, skipped, err)
+	}
+
+	var keys [2]string
+	for i, fileID := range fileIDs {
+		var sha256 sql.NullString
+		var size sql.NullInt64
+		err := config.PostgresDB.QueryRow(`
+			SELECT f.mongo_file_id, f.sha256, f.size FROM Files f
+			JOIN Blobs b ON b.blob_key = f.mongo_file_id AND b.sha256 = f.sha256
+			WHERE f.file_id = $1
+		`, fileID).Scan(&keys[i], &sha256, &size)
+		if err != nil || size.Int64 != int64(len("stored long ago")) {
+			t.Fatalf("file %d: size %v, %v", fileID, size, err)
+		}
+	}
+	if keys[0] != keys[1] {
+		t.Errorf("copies of the same content kept as %s and %s", keys[0], keys[1])
+	}
+
+	// new uploads of the content reuse the backfilled copy
+	uploadedID := uploadFile(t, alice, "again.txt", "stored long ago")
+	var key string
+	config.PostgresDB.QueryRow("SELECT mongo_file_id FROM Files WHERE file_id = $1", uploadedID).Scan(&key)
+	if key != keys[0] {
+		t.Errorf("upload stored as %s, want the backfilled %s", key, keys[0])
+	}
+
+	if added, merged, _, err := handlers.BackfillBlobs(ctx); err != nil || added != 0 || merged != 0 {
+		t.Errorf("second BackfillBlobs = %d, %d, %v, want nothing new", added, merged, err)
+	}
+}
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+)
+
+// File requests let anyone with the /r/{token} URL upload files into a
+// folder of the creator without seeing what is in it. The files belong to
+// the creator and count for their quotas, the uploader's name and mail are
+// kept in FileRequestUploads.
+
+const fileRequestColumns = `request_id, token, folder_id, created_by, title, max_file_size,
+	allowed_types, deadline, revoked_at, create_date`
+
+func scanFileRequest(row interface{ Scan(...interface{}) error }) (models.FileRequest, error) {
+	var req models.FileRequest
+	var title, allowedTypes sql.NullString
+	err := row.Scan(&req.RequestID, &req.Token, &req.FolderID, &req.CreatedBy, &title, &req.MaxFileSize,
+		&allowedTypes, &req.Deadline, &req.RevokedAt, &req.CreateDate)
+	req.Title = title.String
+	req.AllowedTypes = []string{}
+	if allowedTypes.String != "" {
+		req.AllowedTypes = strings.Split(allowedTypes.String, ",")
+	}
+	return req, err
+}
+
+/*
+title: string
+max_file_size: int | null, bytes
+allowed_types: string[], extensions like ".pdf", empty allows any
+deadline: RFC 3339 time | null, null never closes
+*/
+func CreateFileRequest(w http.ResponseWriter, r *http.Request) {
+	folder, ok := ownFolder(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Title        string     `json:"title"`
+		MaxFileSize  *int64     `json:"max_file_size"`
+		AllowedTypes []string   `json:"allowed_types"`
+		Deadline     *time.Time `json:"deadline"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.MaxFileSize != nil && *req.MaxFileSize <= 0 {
+		http.Error(w, "max_file_size must be positive", http.StatusBadRequest)
+		return
+	}
+	if req.Deadline != nil {
+		if !req.Deadline.After(time.Now()) {
+			http.Error(w, "deadline must be in the future", http.StatusBadRequest)
+			return
+		}
+		deadline := req.Deadline.UTC()
+		req.Deadline = &deadline
+	}
+
+	// stored as ".pdf,.docx"
+	var types []string
+	for _, ext := range req.AllowedTypes {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if strings.ContainsAny(ext, ", /") {
+			http.Error(w, "Invalid allowed_types", http.StatusBadRequest)
+			return
+		}
+		types = append(types, ext)
+	}
+	var allowedTypes *string
+	if len(types) > 0 {
+		joined := strings.Join(types, ",")
+		allowedTypes = &joined
+	}
+
+	token, err := newToken()
+	if err != nil {
+		http.Error(w, "Failed to create file request", http.StatusInternalServerError)
+		return
+	}
+
+	fileRequest, err := scanFileRequest(config.PostgresDB.QueryRow(`
+		INSERT INTO FileRequests (token, folder_id, created_by, title, max_file_size, allowed_types, deadline, create_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+fileRequestColumns,
+		token, folder.FolderID, folder.OwnerID, req.Title, req.MaxFileSize, allowedTypes, req.Deadline, time.Now().UTC(),
+	))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(fileRequest)
+}
+
+func GetFileRequests(w http.ResponseWriter, r *http.Request) {
+	folder, ok := ownFolder(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := config.PostgresDB.Query(`
+		SELECT `+fileRequestColumns+` FROM FileRequests WHERE folder_id = $1 ORDER BY request_id DESC
+	`, folder.FolderID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	requests := []models.FileRequest{}
+	for rows.Next() {
+		fileRequest, err := scanFileRequest(rows)
+		if err != nil {
+			http.Error(w, "Row scan error", http.StatusInternalServerError)
+			return
+		}
+		requests = append(requests, fileRequest)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
+
+// GetFileRequestUploads lists the files uploaded through a file request that
+// are not in the trash, newest first
+func GetFileRequestUploads(w http.ResponseWriter, r *http.Request) {
+	fileRequest, ok := ownFileRequest(w, r)
+	if !ok {
+		return
+	}
+
+	rows, err := config.PostgresDB.Query(`
+		SELECT f.file_id, f.name, f.size, u.uploader_name, u.uploader_mail, u.create_date
+		FROM FileRequestUploads u
+		JOIN Files f ON f.file_id = u.file_id
+		WHERE u.request_id = $1 AND f.deleted_at IS NULL
+		ORDER BY u.create_date DESC, f.file_id DESC
+	`, fileRequest.RequestID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	uploads := []models.FileRequestUpload{}
+	for rows.Next() {
+		var upload models.FileRequestUpload
+		var mail sql.NullString
+		err := rows.Scan(&upload.FileID, &upload.Name, &upload.Size, &upload.UploaderName, &mail, &upload.CreateDate)
+		if err != nil {
+			http.Error(w, "Row scan error", http.StatusInternalServerError)
+			return
+		}
+		upload.UploaderMail = mail.String
+		uploads = append(uploads, upload)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploads)
+}
+
+// RevokeFileRequest closes a file request, it is still listed with revoked_at
+func RevokeFileRequest(w http.ResponseWriter, r *http.Request) {
+	fileRequest, ok := ownFileRequest(w, r)
+	if !ok {
+		return
+	}
+
+	_, err := config.PostgresDB.Exec(`
+		UPDATE FileRequests SET revoked_at = COALESCE(revoked_at, $1) WHERE request_id = $2
+	`, time.Now().UTC(), fileRequest.RequestID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "File request revoked"})
+}
+
+// ownFileRequest loads the file request in the {request_id} route variable
+// and checks that the caller created it
+func ownFileRequest(w http.ResponseWriter, r *http.Request) (models.FileRequest, bool) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return models.FileRequest{}, false
+	}
+
+	fileRequest, err := scanFileRequest(config.PostgresDB.QueryRow(`
+		SELECT `+fileRequestColumns+` FROM FileRequests WHERE request_id = $1
+	`, mux.Vars(r)["request_id"]))
+	if err == sql.ErrNoRows || (err == nil && fileRequest.CreatedBy != userID) {
+		http.Error(w, "File request not found", http.StatusNotFound)
+		return models.FileRequest{}, false
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return models.FileRequest{}, false
+	}
+	return fileRequest, true
+}
+
+// OpenFileRequest shows uploaders what they may upload
+func OpenFileRequest(w http.ResponseWriter, r *http.Request) {
+	fileRequest, ok := openFileRequest(w, r)
+	if !ok {
+		return
+	}
+
+	roleLimit, err := maxUploadSize(fileRequest.CreatedBy)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	public := models.PublicFileRequest{
+		Title:        fileRequest.Title,
+		AllowedTypes: fileRequest.AllowedTypes,
+		Deadline:     fileRequest.Deadline,
+	}
+	if maxSize := requestSizeLimit(fileRequest, roleLimit); maxSize > 0 {
+		public.MaxFileSize = &maxSize
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(public)
+}
+
+/*
+form-data uploader_name: string | uploader_mail: string | file: file
+the uploader fields must come before the file, which is checked before it
+is stored
+*/
+func UploadToFileRequest(w http.ResponseWriter, r *http.Request) {
+	fileRequest, ok := openFileRequest(w, r)
+	if !ok {
+		return
+	}
+	ownerID := fileRequest.CreatedBy
+
+	limit, err := newUploadLimit(ownerID, ownerID, 1)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	limit.maxSize = requestSizeLimit(fileRequest, limit.maxSize)
+
+	upload, err := streamUpload(r, limit, func(filename string, fields map[string]string) error {
+		return checkRequestUpload(fileRequest, filename, fields)
+	})
+	switch err {
+	case nil:
+	case errNoUploaderName:
+		http.Error(w, "uploader_name is required before the file", http.StatusBadRequest)
+		return
+	case errUploaderTooLong:
+		http.Error(w, "uploader_name or uploader_mail is too long", http.StatusBadRequest)
+		return
+	case errTypeNotAllowed:
+		http.Error(w, "File type not allowed", http.StatusUnsupportedMediaType)
+		return
+	default:
+		writeUploadError(w, err)
+		return
+	}
+	if upload.Blob.Key == "" {
+		http.Error(w, "File upload error", http.StatusBadRequest)
+		return
+	}
+	uploaderName := strings.TrimSpace(upload.Fields["uploader_name"])
+	uploaderMail := strings.TrimSpace(upload.Fields["uploader_mail"])
+
+	fullPath, err := folderPath(config.PostgresDB, ownerID, &fileRequest.FolderID)
+	if err != nil {
+		http.Error(w, "File request not found", http.StatusNotFound)
+		return
+	}
+
+	err = withTx(func(tx *sql.Tx) error {
+		fileID, err := createFile(tx, ownerID, upload.Blob, upload.Filename, fullPath, "file")
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO FileRequestUploads (file_id, request_id, uploader_name, uploader_mail, create_date)
+			VALUES ($1, $2, $3, $4, $5)
+		`, fileID, fileRequest.RequestID, uploaderName, uploaderMail, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+
+		message := fmt.Sprintf("%q uploaded %q to %q", uploaderName, upload.Filename, fullPath)
+		if err := notify(tx, ownerID, "file_request_upload", message, &fileID, &fileRequest.FolderID); err != nil {
+			return err
+		}
+		return enforceQuotas(tx, ownerID, limit.quotas)
+	})
+	if _, ok := err.(*quotaError); ok {
+		writeUploadError(w, err)
+		return
+	} else if err != nil {
+		http.Error(w, "Saving file metadata error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"message": "File uploaded successfully"})
+}
+
+// openFileRequest loads the file request in the {token} route variable and
+// checks that it is still open
+func openFileRequest(w http.ResponseWriter, r *http.Request) (models.FileRequest, bool) {
+	fileRequest, err := scanFileRequest(config.PostgresDB.QueryRow(`
+		SELECT `+fileRequestColumns+` FROM FileRequests WHERE token = $1
+	`, mux.Vars(r)["token"]))
+	if err == sql.ErrNoRows || (err == nil && fileRequest.RevokedAt != nil) {
+		http.Error(w, "File request not found", http.StatusNotFound)
+		return fileRequest, false
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return fileRequest, false
+	}
+	if fileRequest.Deadline != nil && !fileRequest.Deadline.After(time.Now()) {
+		http.Error(w, "File request closed", http.StatusGone)
+		return fileRequest, false
+	}
+	return fileRequest, true
+}
+
+// requestSizeLimit is the smaller of the request's and the creator's upload
+// limit, 0 if there is none
+func requestSizeLimit(fileRequest models.FileRequest, roleLimit int64) int64 {
+	if fileRequest.MaxFileSize != nil && (roleLimit == 0 || *fileRequest.MaxFileSize < roleLimit) {
+		return *fileRequest.MaxFileSize
+	}
+	return roleLimit
+}
+
+var errNoUploaderName = errors.New("uploader_name is required")
+var errUploaderTooLong = errors.New("uploader_name or uploader_mail is too long")
+var errTypeNotAllowed = errors.New("file type not allowed")
+
+// checkRequestUpload is the partFilter of UploadToFileRequest
+func checkRequestUpload(fileRequest models.FileRequest, filename string, fields map[string]string) error {
+	name := strings.TrimSpace(fields["uploader_name"])
+	if name == "" {
+		return errNoUploaderName
+	}
+	if len(name) > 100 || len(strings.TrimSpace(fields["uploader_mail"])) > 255 {
+		return errUploaderTooLong
+	}
+	if !allowedType(fileRequest.AllowedTypes, filename) {
+		return errTypeNotAllowed
+	}
+	return nil
+}
+
+// allowedType reports whether the file name has one of the extensions, any
+// name is allowed if there are none
+func allowedType(extensions []string, name string) bool {
+	if len(extensions) == 0 {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, allowed := range extensions {
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
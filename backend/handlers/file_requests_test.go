@@ -0,0 +1,85 @@
+package handlers_test
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/models"
+)
+
+// uploadToRequest uploads through a file request as someone without an
+// account, an empty uploader leaves the name out
+func uploadToRequest(t *testing.T, token, uploader, name, content string) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if uploader != "" {
+		form.WriteField("uploader_name", uploader)
+		form.WriteField("uploader_mail", "guest@test")
+	}
+	part, _ := form.CreateFormFile("file", name)
+	part.Write([]byte(content))
+	form.Close()
+	return request(t, "POST", "/r/"+token, "", &body, http.Header{"Content-Type": {form.FormDataContentType()}})
+}
+
+func TestFileRequest(t *testing.T) {
+	alice, bob := newUser(t), newUser(t)
+	folderID := createFolder(t, alice, 0, "applications")
+
+	var fileRequest models.FileRequest
+	body := `{"title":"CVs","max_file_size":10,"allowed_types":["pdf"]}`
+	path := fmt.Sprintf("/api/folders/%d/file-requests", folderID)
+	decode(t, request(t, "POST", path, alice.token, strings.NewReader(body), nil), http.StatusCreated, &fileRequest)
+	readBody(t, request(t, "POST", path, bob.token, strings.NewReader(body), nil), http.StatusForbidden)
+
+	var public models.PublicFileRequest
+	decode(t, request(t, "GET", "/r/"+fileRequest.Token, "", nil, nil), http.StatusOK, &public)
+	if public.Title != "CVs" || public.MaxFileSize == nil || *public.MaxFileSize != 10 || len(public.AllowedTypes) != 1 || public.AllowedTypes[0] != ".pdf" {
+		t.Errorf("public file request = %+v", public)
+	}
+
+	readBody(t, uploadToRequest(t, fileRequest.Token, "", "cv.pdf", "resume"), http.StatusBadRequest)
+	readBody(t, uploadToRequest(t, fileRequest.Token, "Carol", "cv.exe", "resume"), http.StatusUnsupportedMediaType)
+	readBody(t, uploadToRequest(t, fileRequest.Token, "Carol", "cv.pdf", "a long resume"), http.StatusRequestEntityTooLarge)
+	readBody(t, uploadToRequest(t, fileRequest.Token, "Carol", "CV.PDF", "resume"), http.StatusCreated)
+
+	var uploads []models.FileRequestUpload
+	uploadsPath := fmt.Sprintf("/api/file-requests/%d/uploads", fileRequest.RequestID)
+	decode(t, request(t, "GET", uploadsPath, alice.token, nil, nil), http.StatusOK, &uploads)
+	if len(uploads) != 1 || uploads[0].UploaderName != "Carol" || uploads[0].Name != "CV.PDF" {
+		t.Fatalf("uploads = %+v", uploads)
+	}
+	readBody(t, request(t, "GET", uploadsPath, bob.token, nil, nil), http.StatusNotFound)
+
+	// the upload belongs to the creator and lands in the requested folder
+	var ownerID int
+	config.PostgresDB.QueryRow("SELECT owner_id FROM Files WHERE file_id = $1", uploads[0].FileID).Scan(&ownerID)
+	if ownerID != alice.id || folderOf(t, uploads[0].FileID) != "/applications" {
+		t.Errorf("upload owned by %d in %q", ownerID, folderOf(t, uploads[0].FileID))
+	}
+
+	var notifications []models.Notification
+	decode(t, request(t, "GET", "/api/notifications", alice.token, nil, nil), http.StatusOK, &notifications)
+	if len(notifications) != 1 || notifications[0].Type != "file_request_upload" {
+		t.Errorf("notifications = %+v", notifications)
+	}
+
+	// past the deadline the request is closed, revoked it is gone
+	_, err := config.PostgresDB.Exec("UPDATE FileRequests SET deadline = $1 WHERE request_id = $2",
+		time.Now().UTC().Add(-time.Minute), fileRequest.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBody(t, uploadToRequest(t, fileRequest.Token, "Carol", "late.pdf", "resume"), http.StatusGone)
+
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/file-requests/%d", fileRequest.RequestID), bob.token, nil, nil), http.StatusNotFound)
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/file-requests/%d", fileRequest.RequestID), alice.token, nil, nil), http.StatusOK)
+	readBody(t, request(t, "GET", "/r/"+fileRequest.Token, "", nil, nil), http.StatusNotFound)
+}
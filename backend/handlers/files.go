@@ -1,136 +1,152 @@
 package handlers
 
 import (
-	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"log"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
-
-	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/gridfs"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 
 	"backend/config"
 	"backend/middleware"
 	"backend/models"
+	"backend/storage"
 )
 
 /*
-form-data file: file
+form-data file: file | folder_id: int | full_path: string | type: string
+full_path is used without folder_id, missing folders are created
 */
 func UploadFile(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseMultipartForm(50 << 20) // 50MB
-	if err != nil {
-		http.Error(w, "Error parsing form", http.StatusBadRequest)
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
 		return
 	}
 
-	file, header, err := r.FormFile("file")
+	limit, err := newUploadLimit(userID, userID, 1)
 	if err != nil {
-		http.Error(w, "File upload error", http.StatusBadRequest)
+		writeUploadError(w, err)
 		return
 	}
-	defer file.Close()
 
-	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
-	if !ok {
-		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+	upload, err := streamUpload(r, limit, nil)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	if upload.Blob.Key == "" {
+		http.Error(w, "File upload error", http.StatusBadRequest)
 		return
 	}
 
-	fullPath := r.FormValue("full_path")
-	if fullPath == "" {
-		fullPath = "/"
+	fullPath := upload.Fields["full_path"]
+	if value := upload.Fields["folder_id"]; value != "" {
+		folderID, err := strconv.Atoi(value)
+		if err == nil {
+			fullPath, err = folderPath(config.PostgresDB, userID, &folderID)
+		}
+		if err != nil {
+			http.Error(w, "Folder not found", http.StatusNotFound)
+			return
+		}
 	}
 
-	fileType := r.FormValue("type")
+	fileType := upload.Fields["type"]
 	if fileType == "" {
 		fileType = "file"
 	}
 
-	bucket, err := gridfs.NewBucket(config.DB)
-	if err != nil {
-		http.Error(w, "GridFS initialization error", http.StatusInternalServerError)
+	var fileID int
+	err = withTx(func(tx *sql.Tx) error {
+		fileID, err = createFile(tx, userID, upload.Blob, upload.Filename, fullPath, fileType)
+		if err != nil {
+			return err
+		}
+		return enforceQuotas(tx, userID, limit.quotas)
+	})
+	if _, ok := err.(*quotaError); ok {
+		writeUploadError(w, err)
 		return
-	}
-
-	uploadStream, err := bucket.OpenUploadStream(
-		header.Filename,
-		options.GridFSUpload().SetMetadata(bson.M{"owner_id": userID}),
-	)
-	if err != nil {
-		http.Error(w, "Saving file error", http.StatusInternalServerError)
+	} else if err == errBadFolderName {
+		http.Error(w, "Invalid full_path", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		http.Error(w, "Saving file metadata error", http.StatusInternalServerError)
 		return
 	}
-	defer uploadStream.Close()
 
-	_, err = io.Copy(uploadStream, file)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "File uploaded successfully",
+		"file_id": fileID,
+	})
+}
+
+// createFile adds the Files row for a stored blob together with its default
+// "1.0" version, in the folder at fullPath
+func createFile(tx *sql.Tx, userID int, blob storedBlob, name, fullPath, fileType string) (int, error) {
+	folderID, fullPath, err := ensureFolder(tx, userID, fullPath)
 	if err != nil {
-		http.Error(w, "File write error", http.StatusInternalServerError)
-		return
+		return 0, err
 	}
 
-	mongoID := uploadStream.FileID.(primitive.ObjectID)
-	mongoFileIDStr := mongoID.Hex()
-
 	var fileID int
-	err = config.PostgresDB.QueryRow(`
-		INSERT INTO Files (owner_id, mongo_file_id, name, full_path, type)
-		VALUES ($1, $2, $3, $4, $5)
+	err = tx.QueryRow(`
+		INSERT INTO Files (owner_id, mongo_file_id, sha256, size, name, full_path, folder_id, type)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING file_id
-	`, userID, mongoFileIDStr, header.Filename, fullPath, fileType).Scan(&fileID)
+	`, userID, blob.Key, blob.SHA256, blob.Size, name, fullPath, folderID, fileType).Scan(&fileID)
 	if err != nil {
-		http.Error(w, "Saving file metadata error", http.StatusInternalServerError)
-		return
+		return 0, err
 	}
 
 	// Create default version
 	var versionID int
-	err = config.PostgresDB.QueryRow(`
-		INSERT INTO FileVersions (user_id, file_id, mongo_file_id, name)
-		VALUES ($1, $2, $3, $4)
+	err = tx.QueryRow(`
+		INSERT INTO FileVersions (user_id, file_id, mongo_file_id, sha256, size, name)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING version_id
-	`, userID, fileID, mongoFileIDStr, "1.0").Scan(&versionID)
+	`, userID, fileID, blob.Key, blob.SHA256, blob.Size, "1.0").Scan(&versionID)
 	if err != nil {
-		http.Error(w, "Saving version error", http.StatusInternalServerError)
-		return
+		return 0, err
 	}
 
 	// Add new version to file
-	_, err = config.PostgresDB.Exec(`
+	_, err = tx.Exec(`
 		UPDATE Files
 		SET version_id = $1
 		WHERE file_id = $2
 	`, versionID, fileID)
 	if err != nil {
-		http.Error(w, "Updating file version error", http.StatusInternalServerError)
-		return
+		return 0, err
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "File uploaded successfully",
-		"file_id": fileID,
-	})
+	return fileID, claimBlob(tx, blob.Key)
 }
 
 func DownloadFile(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	fileID := vars["file_id"]
+	auth, ok := authorizeFile(w, r, accessDownload)
+	if !ok {
+		return
+	}
+	serveFile(w, r, auth.FileID)
+}
 
-	var mongoFileIDStr, fileName, fileType string
+// serveFile answers with the content of the file
+func serveFile(w http.ResponseWriter, r *http.Request, fileID int) {
+	var blobKey, fileName, fileType string
 
 	err := config.PostgresDB.QueryRow(`
 		SELECT mongo_file_id, name, type
 		FROM Files
-		WHERE file_id = $1
-	`, fileID).Scan(&mongoFileIDStr, &fileName, &fileType)
+		WHERE file_id = $1 AND deleted_at IS NULL
+	`, fileID).Scan(&blobKey, &fileName, &fileType)
 
 	if err == sql.ErrNoRows {
 		http.Error(w, "The file was not found in PostgreSQL", http.StatusNotFound)
@@ -140,15 +156,12 @@ func DownloadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mongoFileID, err := primitive.ObjectIDFromHex(mongoFileIDStr)
-	if err != nil {
-		http.Error(w, "Invalid mongo_file_id", http.StatusInternalServerError)
+	blob, err := config.Blobs.Stat(r.Context(), blobKey)
+	if err == storage.ErrNotFound {
+		http.Error(w, "The file was not found in storage", http.StatusNotFound)
 		return
-	}
-
-	bucket, err := gridfs.NewBucket(config.DB)
-	if err != nil {
-		http.Error(w, "GridFS initialization error", http.StatusInternalServerError)
+	} else if err != nil {
+		http.Error(w, "Error reading from storage", http.StatusInternalServerError)
 		return
 	}
 
@@ -174,13 +187,38 @@ func DownloadFile(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", fileName))
 
-	_, err = bucket.DownloadToStream(mongoFileID, w)
-	if err != nil {
-		http.Error(w, "Error downloading a file from GridFS", http.StatusInternalServerError)
-		return
+	// a blob key always refers to the same bytes, so it is a strong ETag
+	w.Header().Set("ETag", `"`+blobKey+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	// ServeContent handles Range (multi-range too), If-None-Match,
+	// If-Modified-Since, If-Range and Content-Length
+	content := storage.NewBlobReader(r.Context(), config.Blobs, blobKey, blob.Size)
+	defer content.Close()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	http.ServeContent(sw, r, fileName, blob.UploadDate, content)
+
+	// for the stale files report, only whole downloads count as access,
+	// not ranges, HEAD or 304 revalidations
+	if r.Method == http.MethodGet && sw.status == http.StatusOK {
+		_, err := config.PostgresDB.Exec("UPDATE Files SET access_date = $1 WHERE file_id = $2", time.Now().UTC(), fileID)
+		if err != nil {
+			log.Printf("⚠️ Не удалось обновить дату доступа к файлу %d: %v", fileID, err)
+		}
 	}
 }
 
+// statusWriter remembers the status code sent to the client
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
 func GetUserFiles(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok {
@@ -190,148 +228,148 @@ func GetUserFiles(w http.ResponseWriter, r *http.Request) {
 
 	search := r.URL.Query().Get("search") // получаем параметр поиска
 
-	var (
-		query string
-		args  []interface{}
-	)
-
+	var files []models.FileMetadata
+	var err error
 	if search != "" {
-		query = `
-			SELECT 
-				file_id, name, type, full_path, create_date, edit_date,
-				version_id, owner_id
-			FROM Files
-			WHERE owner_id = $1 AND name ILIKE $2
-		`
-		args = append(args, userID, "%"+search+"%")
+		files, err = listFiles("f.owner_id = $1 AND f.deleted_at IS NULL AND f.name ILIKE $2", userID, "%"+search+"%")
 	} else {
-		query = `
-			SELECT 
-				file_id, name, type, full_path, create_date, edit_date,
-				version_id, owner_id
-			FROM Files
-			WHERE owner_id = $1
-		`
-		args = append(args, userID)
+		files, err = listFiles("f.owner_id = $1 AND f.deleted_at IS NULL", userID)
 	}
-
-	rows, err := config.PostgresDB.Query(query, args...)
 	if err != nil {
 		http.Error(w, "Database query error: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	if len(files) == 0 {
+		http.Error(w, "There are no files", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(files); err != nil {
+		http.Error(w, "Encoding error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// listFiles returns files with their blob metadata; filter is the WHERE
+// clause on Files f and may end with ORDER BY
+func listFiles(filter string, args ...interface{}) ([]models.FileMetadata, error) {
+	rows, err := config.PostgresDB.Query(`
+		SELECT
+			f.file_id, f.name, f.type, f.full_path, f.folder_id, f.create_date, f.edit_date,
+			f.version_id, f.owner_id,
+			f.sha256, f.size, b.stored_size, b.verified_at, b.verify_error
+		FROM Files f
+		LEFT JOIN Blobs b ON b.blob_key = f.mongo_file_id
+		WHERE `+filter, args...)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
-	var files []models.FileMetadata
+	files := []models.FileMetadata{}
 	for rows.Next() {
 		var file models.FileMetadata
-		err := rows.Scan(
+		var blob blobColumns
+		err := rows.Scan(append([]interface{}{
 			&file.FileID,
 			&file.Name,
 			&file.Type,
 			&file.FullPath,
+			&file.FolderID,
 			&file.CreateDate,
 			&file.EditDate,
 			&file.VersionID,
 			&file.OwnerID,
-		)
+		}, blob.dest()...)...)
 		if err != nil {
-			http.Error(w, "Row scan error: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil, err
 		}
+		file.BlobMetadata = blob.metadata()
 		files = append(files, file)
 	}
-
-	if len(files) == 0 {
-		http.Error(w, "There are no files", http.StatusNotFound)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(files); err != nil {
-		http.Error(w, "Encoding error", http.StatusInternalServerError)
-		return
-	}
+	return files, rows.Err()
 }
 
 /*
 form-data file: file | name: string
 */
 func UpdateFile(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	fileID := params["file_id"]
 	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
 	if !ok {
 		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
 		return
 	}
+	auth, ok := authorizeFile(w, r, accessEdit)
+	if !ok {
+		return
+	}
+	fileID := auth.FileID
 
-	// Check owner
-	var ownerID int
-	var mongoFileID, fileName string
-	err := config.PostgresDB.QueryRow("SELECT owner_id, mongo_file_id, name FROM Files WHERE file_id = $1", fileID).Scan(&ownerID, &mongoFileID, &fileName)
+	var blobKey, fileName string
+	err := config.PostgresDB.QueryRow("SELECT mongo_file_id, name FROM Files WHERE file_id = $1", fileID).Scan(&blobKey, &fileName)
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
-	if ownerID != userID {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
 
 	var requestData struct {
 		Name string `json:"name"`
 	}
+	var upload *streamedUpload
+	var limit uploadLimit
 	if r.Header.Get("Content-Type") == "application/json" {
 		err := json.NewDecoder(r.Body).Decode(&requestData)
 		if err != nil {
 			http.Error(w, "Invalid JSON", http.StatusBadRequest)
 			return
 		}
+	} else if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		limit, err = newUploadLimit(userID, auth.OwnerID, 0)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		upload, err = streamUpload(r, limit, nil)
+		if err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		requestData.Name = upload.Fields["name"]
 	}
 	newFileName := requestData.Name
 	if newFileName == "" {
 		newFileName = fileName
 	}
 
-	file, header, err := r.FormFile("file")
-	if err == nil {
-		defer file.Close()
-
+	if upload != nil && upload.Blob.Key != "" {
 		// Change filename if exist
-		if header.Filename != "" {
-			newFileName = header.Filename
+		if upload.Filename != "" {
+			newFileName = upload.Filename
 		}
 
-		// Delete old file in mongo
-		collection := config.DB.Collection("fs.files")
-		_, err = collection.DeleteOne(context.TODO(), bson.M{"_id": mongoFileID})
-		if err != nil {
-			http.Error(w, "Failed to delete old file from storage", http.StatusInternalServerError)
-			return
-		}
-
-		// Load new file in mongo
-		bucket, err := gridfs.NewBucket(config.DB)
-		if err != nil {
-			http.Error(w, "Failed to get GridFS bucket", http.StatusInternalServerError)
+		err = withTx(func(tx *sql.Tx) error {
+			_, err := tx.Exec(`
+				UPDATE Files SET mongo_file_id = $1, sha256 = $2, size = $3, name = $4, edit_date = NOW()
+				WHERE file_id = $5
+			`, upload.Blob.Key, upload.Blob.SHA256, upload.Blob.Size, newFileName, fileID)
+			if err != nil {
+				return err
+			}
+			if err := claimBlob(tx, upload.Blob.Key); err != nil {
+				return err
+			}
+			// the old blob is deleted later unless a version still points to it
+			if err := releaseBlob(tx, blobKey); err != nil {
+				return err
+			}
+			return enforceQuotas(tx, auth.OwnerID, limit.quotas)
+		})
+		if _, ok := err.(*quotaError); ok {
+			writeUploadError(w, err)
 			return
-		}
-		uploadStream, err := bucket.OpenUploadStream(fmt.Sprintf("file_%s", fileID))
-		if err != nil {
-			http.Error(w, "Failed to upload file", http.StatusInternalServerError)
-			return
-		}
-		defer uploadStream.Close()
-		_, err = io.Copy(uploadStream, file)
-		if err != nil {
-			http.Error(w, "Failed to write file to storage", http.StatusInternalServerError)
-			return
-		}
-		newMongoFileID := uploadStream.FileID.(primitive.ObjectID).Hex()
-
-		_, err = config.PostgresDB.Exec("UPDATE Files SET mongo_file_id = $1, name = $2, edit_date = NOW() WHERE file_id = $3", newMongoFileID, newFileName, fileID)
-		if err != nil {
+		} else if err != nil {
 			http.Error(w, "Failed to update file metadata", http.StatusInternalServerError)
 			return
 		}
@@ -347,41 +385,235 @@ func UpdateFile(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "File updated"})
 }
 
+// DeleteFile moves the file with its versions and shares to the owner's trash
 func DeleteFile(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	fileID := params["file_id"]
-	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	auth, ok := authorizeFile(w, r, accessFullControl)
 	if !ok {
-		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
 		return
 	}
 
-	// Check owner
-	var ownerID int
-	var mongoFileID string
-	err := config.PostgresDB.QueryRow("SELECT owner_id, mongo_file_id FROM Files WHERE file_id = $1", fileID).Scan(&ownerID, &mongoFileID)
+	_, err := config.PostgresDB.Exec("UPDATE Files SET deleted_at = $1 WHERE file_id = $2", time.Now().UTC(), auth.FileID)
 	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
+		http.Error(w, "Failed to delete file from DB", http.StatusInternalServerError)
 		return
 	}
-	if ownerID != userID {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "File moved to trash"})
+}
+
+// purgeFile deletes the file row, which cascades to its versions and shares
+func purgeFile(tx *sql.Tx, fileID int) error {
+	// Collect blobs of the file and all its versions
+	rows, err := tx.Query(`
+		SELECT mongo_file_id FROM Files WHERE file_id = $1 AND mongo_file_id IS NOT NULL
+		UNION
+		SELECT mongo_file_id FROM FileVersions WHERE file_id = $1 AND mongo_file_id IS NOT NULL
+	`, fileID)
+	if err != nil {
+		return err
 	}
 
-	collection := config.DB.Collection("fs.files")
-	_, err = collection.DeleteOne(context.TODO(), bson.M{"_id": mongoFileID})
+	var blobKeys []string
+	for rows.Next() {
+		var blobKey string
+		if err := rows.Scan(&blobKey); err != nil {
+			rows.Close()
+			return err
+		}
+		blobKeys = append(blobKeys, blobKey)
+	}
+	rows.Close()
+
+	_, err = tx.Exec("DELETE FROM Files WHERE file_id = $1", fileID)
 	if err != nil {
-		http.Error(w, "Failed to delete file from storage", http.StatusInternalServerError)
+		return err
+	}
+
+	// the collector deletes the blobs no other file refers to
+	for _, blobKey := range blobKeys {
+		if err := releaseBlob(tx, blobKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+folder_id: int | full_path: string | name: string
+full_path is used without folder_id, missing folders are created;
+name keeps the current name if empty. Others than the owner must give the
+folder_id of a folder they can edit.
+*/
+func MoveFile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+	auth, ok := authorizeFile(w, r, accessFullControl)
+	if !ok {
 		return
 	}
 
-	_, err = config.PostgresDB.Exec("DELETE FROM Files WHERE file_id = $1", fileID)
-	if err != nil {
-		http.Error(w, "Failed to delete file from DB", http.StatusInternalServerError)
+	var req struct {
+		FolderID *int   `json:"folder_id"`
+		FullPath string `json:"full_path"`
+		Name     string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if userID != auth.OwnerID && req.FolderID == nil {
+		http.Error(w, "folder_id is required to move a file you do not own", http.StatusBadRequest)
+		return
+	}
+
+	// the file stays in its owner's folders
+	err := withTx(func(tx *sql.Tx) error {
+		folderID, path, err := targetFolder(tx, auth.OwnerID, req.FolderID, req.FullPath)
+		if err != nil {
+			return err
+		}
+		if userID != auth.OwnerID {
+			folder, err := scanFolder(tx.QueryRow(`SELECT `+folderColumns+` FROM Folders WHERE folder_id = $1`, *folderID))
+			if err != nil {
+				return err
+			}
+			level, err := folderAccess(tx, folder, userID)
+			if err != nil {
+				return err
+			}
+			if level < accessEdit {
+				return errMoveForbidden
+			}
+		}
+		_, err = tx.Exec(`
+			UPDATE Files SET folder_id = $1, full_path = $2, name = COALESCE(NULLIF($3, ''), name), edit_date = NOW()
+			WHERE file_id = $4
+		`, folderID, path, req.Name, auth.FileID)
+		return err
+	})
+	if err == errMoveForbidden {
+		http.Error(w, "Cannot move into a folder you cannot edit", http.StatusForbidden)
+		return
+	} else if err != nil {
+		writeFolderError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "File deleted"})
+	json.NewEncoder(w).Encode(map[string]string{"message": "File moved"})
+}
+
+var errMoveForbidden = errors.New("no edit access to the destination folder")
+
+/*
+folder_id: int | full_path: string | name: string | versions: bool
+the copy belongs to the caller and is placed and named like in MoveFile;
+with versions it gets the whole version history, otherwise only the current
+content as version "1.0"
+*/
+func CopyFile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+	auth, ok := authorizeFile(w, r, accessDownload)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		FolderID *int   `json:"folder_id"`
+		FullPath string `json:"full_path"`
+		Name     string `json:"name"`
+		Versions bool   `json:"versions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var copyID int
+	err := withTx(func(tx *sql.Tx) error {
+		before, err := loadQuotas(tx, userID)
+		if err != nil {
+			return err
+		}
+		folderID, path, err := targetFolder(tx, userID, req.FolderID, req.FullPath)
+		if err != nil {
+			return err
+		}
+		copyID, err = copyFile(tx, auth.FileID, userID, folderID, path, req.Name, req.Versions)
+		if err != nil {
+			return err
+		}
+		return enforceQuotas(tx, userID, before)
+	})
+	if _, ok := err.(*quotaError); ok {
+		writeUploadError(w, err)
+		return
+	} else if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "File copied",
+		"file_id": copyID,
+	})
+}
+
+// copyFile adds a file owned by ownerID that refers to the same blobs as
+// fileID, so no content is stored twice. An empty name keeps the name of
+// fileID. Shares are not copied.
+func copyFile(tx *sql.Tx, fileID, ownerID int, folderID *int, fullPath, name string, withVersions bool) (int, error) {
+	var copyID int
+	err := tx.QueryRow(`
+		INSERT INTO Files (owner_id, mongo_file_id, sha256, size, name, full_path, folder_id, type)
+		SELECT $1, mongo_file_id, sha256, size, COALESCE(NULLIF($2, ''), name), $3, $4, type FROM Files WHERE file_id = $5
+		RETURNING file_id
+	`, ownerID, name, fullPath, folderID, fileID).Scan(&copyID)
+	if err != nil {
+		return 0, err
+	}
+
+	if !withVersions {
+		var versionID int
+		err = tx.QueryRow(`
+			INSERT INTO FileVersions (user_id, file_id, mongo_file_id, sha256, size, name)
+			SELECT $1, file_id, mongo_file_id, sha256, size, '1.0' FROM Files WHERE file_id = $2
+			RETURNING version_id
+		`, ownerID, copyID).Scan(&versionID)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec("UPDATE Files SET version_id = $1 WHERE file_id = $2", versionID, copyID)
+		return copyID, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO FileVersions (file_id, user_id, name, create_date, edit_date, mongo_file_id, sha256, size)
+		SELECT $1, user_id, name, create_date, edit_date, mongo_file_id, sha256, size
+		FROM FileVersions WHERE file_id = $2
+	`, copyID, fileID)
+	if err != nil {
+		return 0, err
+	}
+
+	// version names are unique per file, so they map the current version
+	_, err = tx.Exec(`
+		UPDATE Files SET version_id = (
+			SELECT c.version_id FROM FileVersions c
+			JOIN FileVersions v ON v.name = c.name
+			JOIN Files f ON f.version_id = v.version_id
+			WHERE c.file_id = $1 AND f.file_id = $2
+		)
+		WHERE file_id = $1
+	`, copyID, fileID)
+	return copyID, err
 }
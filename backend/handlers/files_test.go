@@ -0,0 +1,46 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"backend/config"
+)
+
+func TestUploadAndDownload(t *testing.T) {
+	alice := newUser(t)
+	fileID := uploadFile(t, alice, "hello.txt", "hello world")
+	path := fmt.Sprintf("/api/files/%d", fileID)
+
+	resp := request(t, "GET", path, alice.token, nil, http.Header{"Range": {"bytes=6-"}})
+	if body := readBody(t, resp, http.StatusPartialContent); body != "world" {
+		t.Errorf("range body = %q", body)
+	}
+	var accessed bool
+	config.PostgresDB.QueryRow("SELECT access_date IS NOT NULL FROM Files WHERE file_id = $1", fileID).Scan(&accessed)
+	if accessed {
+		t.Error("a range request counted as access")
+	}
+
+	resp = request(t, "GET", path, alice.token, nil, nil)
+	etag := resp.Header.Get("ETag")
+	if body := readBody(t, resp, http.StatusOK); body != "hello world" {
+		t.Errorf("body = %q", body)
+	}
+	config.PostgresDB.QueryRow("SELECT access_date IS NOT NULL FROM Files WHERE file_id = $1", fileID).Scan(&accessed)
+	if !accessed {
+		t.Error("a full download did not count as access")
+	}
+
+	resp = request(t, "GET", path, alice.token, nil, http.Header{"If-None-Match": {etag}})
+	readBody(t, resp, http.StatusNotModified)
+
+	var files []struct {
+		FileID int `json:"file_id"`
+	}
+	decode(t, request(t, "GET", "/api/files", alice.token, nil, nil), http.StatusOK, &files)
+	if len(files) != 1 || files[0].FileID != fileID {
+		t.Errorf("files = %+v", files)
+	}
+}
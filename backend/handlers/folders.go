@@ -0,0 +1,920 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+)
+
+// Folders form a tree per owner; top level folders have no parent. Names
+// are unique among the folders of a parent. Folders.full_path and the
+// full_path of files are kept equal to the path of names from the top,
+// e.g. "/projects/2024", files at the top have "/".
+
+var errBadFolderName = errors.New("invalid folder name")
+var errFolderExists = errors.New("a folder with this name already exists")
+
+// uniqueViolation tells whether err comes from the folders_name index, when
+// a concurrent request took the name between the check and the write
+func uniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+func validFolderName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/") && len(name) <= 255
+}
+
+// splitPath turns a full_path into folder names; "." and empty names are
+// skipped, ".." is not allowed
+func splitPath(fullPath string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(fullPath, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		if !validFolderName(name) {
+			return nil, errBadFolderName
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func childPath(parentPath, name string) string {
+	return strings.TrimSuffix(parentPath, "/") + "/" + name
+}
+
+// ensureFolder returns the folder at fullPath, creating missing folders,
+// and the normalized path. The top level is a nil folder with path "/".
+func ensureFolder(tx *sql.Tx, ownerID int, fullPath string) (*int, string, error) {
+	names, err := splitPath(fullPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var parentID *int
+	path := "/"
+	for _, name := range names {
+		path = childPath(path, name)
+		_, err := tx.Exec(`
+			INSERT INTO Folders (owner_id, parent_id, name, full_path)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING
+		`, ownerID, parentID, name, path)
+		if err != nil {
+			return nil, "", err
+		}
+
+		var folderID int
+		err = tx.QueryRow(`
+			SELECT folder_id FROM Folders
+			WHERE owner_id = $1 AND COALESCE(parent_id, 0) = $2 AND name = $3
+		`, ownerID, intOrZero(parentID), name).Scan(&folderID)
+		if err != nil {
+			return nil, "", err
+		}
+		parentID = &folderID
+	}
+	return parentID, path, nil
+}
+
+func intOrZero(n *int) int {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
+
+const folderColumns = `folder_id, owner_id, parent_id, name, full_path, create_date, edit_date`
+
+func scanFolder(row interface{ Scan(...interface{}) error }) (models.Folder, error) {
+	var f models.Folder
+	err := row.Scan(&f.FolderID, &f.OwnerID, &f.ParentID, &f.Name, &f.FullPath, &f.CreateDate, &f.EditDate)
+	return f, err
+}
+
+// ownFolder loads the folder in the {folder_id} route variable and checks
+// that the caller owns it
+func ownFolder(w http.ResponseWriter, r *http.Request) (models.Folder, bool) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return models.Folder{}, false
+	}
+	folderID, err := strconv.Atoi(mux.Vars(r)["folder_id"])
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return models.Folder{}, false
+	}
+
+	folder, err := scanFolder(config.PostgresDB.QueryRow(`SELECT `+folderColumns+` FROM Folders WHERE folder_id = $1`, folderID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return models.Folder{}, false
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return models.Folder{}, false
+	}
+	if folder.OwnerID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return models.Folder{}, false
+	}
+	return folder, true
+}
+
+// folderPath returns the path of the caller's folder, "/" for nil
+func folderPath(q queryer, ownerID int, folderID *int) (string, error) {
+	if folderID == nil {
+		return "/", nil
+	}
+	var path string
+	var owner int
+	err := q.QueryRow("SELECT full_path, owner_id FROM Folders WHERE folder_id = $1", *folderID).Scan(&path, &owner)
+	if err == nil && owner != ownerID {
+		err = sql.ErrNoRows
+	}
+	return path, err
+}
+
+// targetFolder resolves where a file or folder goes: the caller's folder
+// folderID, or else the folder at fullPath, which is created if missing
+func targetFolder(tx *sql.Tx, ownerID int, folderID *int, fullPath string) (*int, string, error) {
+	if folderID != nil {
+		path, err := folderPath(tx, ownerID, folderID)
+		return folderID, path, err
+	}
+	return ensureFolder(tx, ownerID, fullPath)
+}
+
+// folderNameTaken tells whether parentID already has a folder named name
+func folderNameTaken(q queryer, ownerID int, parentID *int, name string) (bool, error) {
+	var exists bool
+	err := q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM Folders
+			WHERE owner_id = $1 AND COALESCE(parent_id, 0) = $2 AND name = $3
+		)
+	`, ownerID, intOrZero(parentID), name).Scan(&exists)
+	return exists, err
+}
+
+// rewriteFolderPaths sets the path of a folder and updates its subfolders
+// and files, including those in the trash
+func rewriteFolderPaths(tx *sql.Tx, folderID int, path string) error {
+	_, err := tx.Exec("UPDATE Folders SET full_path = $1, edit_date = NOW() WHERE folder_id = $2", path, folderID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE Files SET full_path = $1 WHERE folder_id = $2", path, folderID)
+	if err != nil {
+		return err
+	}
+
+	children, err := childFolders(tx, folderID)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := rewriteFolderPaths(tx, child.FolderID, childPath(path, child.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func childFolders(tx *sql.Tx, folderID int) ([]models.Folder, error) {
+	rows, err := tx.Query(`SELECT `+folderColumns+` FROM Folders WHERE parent_id = $1`, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var folders []models.Folder
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+// subtreeFolders returns the folder and all folders below it
+func subtreeFolders(tx *sql.Tx, folderID int) ([]int, error) {
+	ids := []int{folderID}
+	for i := 0; i < len(ids); i++ {
+		children, err := childFolders(tx, ids[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			ids = append(ids, child.FolderID)
+		}
+	}
+	return ids, nil
+}
+
+// writeFolderError answers with a matching status for folder errors
+func writeFolderError(w http.ResponseWriter, err error) {
+	if uniqueViolation(err) {
+		err = errFolderExists
+	}
+	switch err {
+	case errBadFolderName:
+		http.Error(w, "Invalid folder name", http.StatusBadRequest)
+	case errFolderExists:
+		http.Error(w, "A folder with this name already exists", http.StatusConflict)
+	case sql.ErrNoRows:
+		http.Error(w, "Parent folder not found", http.StatusNotFound)
+	default:
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
+}
+
+/*
+name: string
+parent_id: int | null
+*/
+func CreateFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name     string `json:"name"`
+		ParentID *int   `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Name) {
+		writeFolderError(w, errBadFolderName)
+		return
+	}
+
+	var folder models.Folder
+	err := withTx(func(tx *sql.Tx) error {
+		parentPath, err := folderPath(tx, userID, req.ParentID)
+		if err != nil {
+			return err
+		}
+		taken, err := folderNameTaken(tx, userID, req.ParentID, req.Name)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errFolderExists
+		}
+
+		folder, err = scanFolder(tx.QueryRow(`
+			INSERT INTO Folders (owner_id, parent_id, name, full_path)
+			VALUES ($1, $2, $3, $4)
+			RETURNING `+folderColumns,
+			userID, req.ParentID, req.Name, childPath(parentPath, req.Name)))
+		return err
+	})
+	if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(folder)
+}
+
+func GetFolder(w http.ResponseWriter, r *http.Request) {
+	auth, ok := authorizeFolder(w, r, accessView)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auth.Folder)
+}
+
+/*
+name: string
+*/
+func RenameFolder(w http.ResponseWriter, r *http.Request) {
+	folder, ok := ownFolder(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !validFolderName(req.Name) {
+		writeFolderError(w, errBadFolderName)
+		return
+	}
+
+	err := withTx(func(tx *sql.Tx) error {
+		parentPath, err := folderPath(tx, folder.OwnerID, folder.ParentID)
+		if err != nil {
+			return err
+		}
+		if req.Name == folder.Name {
+			return nil
+		}
+		taken, err := folderNameTaken(tx, folder.OwnerID, folder.ParentID, req.Name)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return errFolderExists
+		}
+
+		_, err = tx.Exec("UPDATE Folders SET name = $1 WHERE folder_id = $2", req.Name, folder.FolderID)
+		if err != nil {
+			return err
+		}
+		return rewriteFolderPaths(tx, folder.FolderID, childPath(parentPath, req.Name))
+	})
+	if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Folder renamed"})
+}
+
+/*
+parent_id: int | null | full_path: string | name: string
+full_path of the new parent is used without parent_id, missing folders are
+created; name keeps the current name if empty
+*/
+func MoveFolder(w http.ResponseWriter, r *http.Request) {
+	folder, ok := ownFolder(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ParentID *int   `json:"parent_id"`
+		FullPath string `json:"full_path"`
+		Name     string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		req.Name = folder.Name
+	}
+	if !validFolderName(req.Name) {
+		writeFolderError(w, errBadFolderName)
+		return
+	}
+
+	err := withTx(func(tx *sql.Tx) error {
+		parentID, _, err := targetFolder(tx, folder.OwnerID, req.ParentID, req.FullPath)
+		if err != nil {
+			return err
+		}
+		return moveFolder(tx, folder, parentID, req.Name)
+	})
+	if err == errFolderCycle {
+		http.Error(w, "Cannot move a folder into itself", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Folder moved"})
+}
+
+var errFolderCycle = errors.New("cannot move a folder into itself")
+
+// moveFolder puts the folder under parentID with the given name
+func moveFolder(tx *sql.Tx, folder models.Folder, parentID *int, name string) error {
+	parentPath, err := folderPath(tx, folder.OwnerID, parentID)
+	if err != nil {
+		return err
+	}
+
+	// the new parent must not be the folder or below it
+	for id := parentID; id != nil; {
+		if *id == folder.FolderID {
+			return errFolderCycle
+		}
+		var next *int
+		if err := tx.QueryRow("SELECT parent_id FROM Folders WHERE folder_id = $1", *id).Scan(&next); err != nil {
+			return err
+		}
+		id = next
+	}
+
+	if intOrZero(parentID) == intOrZero(folder.ParentID) && name == folder.Name {
+		return nil
+	}
+	taken, err := folderNameTaken(tx, folder.OwnerID, parentID, name)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errFolderExists
+	}
+
+	_, err = tx.Exec("UPDATE Folders SET parent_id = $1, name = $2 WHERE folder_id = $3", parentID, name, folder.FolderID)
+	if err != nil {
+		return err
+	}
+	return rewriteFolderPaths(tx, folder.FolderID, childPath(parentPath, name))
+}
+
+/*
+parent_id: int | null | full_path: string | name: string | versions: bool
+the copy is placed like in MoveFolder; files in the trash are not copied,
+versions is as in CopyFile
+*/
+func CopyFolder(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+	auth, ok := authorizeFolder(w, r, accessDownload)
+	if !ok {
+		return
+	}
+	folder := auth.Folder
+
+	var req struct {
+		ParentID *int   `json:"parent_id"`
+		FullPath string `json:"full_path"`
+		Name     string `json:"name"`
+		Versions bool   `json:"versions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		req.Name = folder.Name
+	}
+	if !validFolderName(req.Name) {
+		writeFolderError(w, errBadFolderName)
+		return
+	}
+
+	var copied models.Folder
+	err := withTx(func(tx *sql.Tx) error {
+		before, err := loadQuotas(tx, userID)
+		if err != nil {
+			return err
+		}
+		parentID, parentPath, err := targetFolder(tx, userID, req.ParentID, req.FullPath)
+		if err != nil {
+			return err
+		}
+		copied, err = copyFolder(tx, folder, userID, parentID, parentPath, req.Name, req.Versions)
+		if err != nil {
+			return err
+		}
+		return enforceQuotas(tx, userID, before)
+	})
+	if _, ok := err.(*quotaError); ok {
+		writeUploadError(w, err)
+		return
+	} else if err != nil {
+		writeFolderError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(copied)
+}
+
+// copyFolder copies the folder with its subfolders and files under parentID
+// of ownerID. The subtree is read before copying, so a folder can be copied
+// into itself. Subfolders and files ownerID has no access to are left out.
+func copyFolder(tx *sql.Tx, folder models.Folder, ownerID int, parentID *int, parentPath, name string, withVersions bool) (models.Folder, error) {
+	taken, err := folderNameTaken(tx, ownerID, parentID, name)
+	if err != nil {
+		return models.Folder{}, err
+	}
+	if taken {
+		return models.Folder{}, errFolderExists
+	}
+
+	// parents come before their children
+	subtree := []models.Folder{folder}
+	for i := 0; i < len(subtree); i++ {
+		children, err := childFolders(tx, subtree[i].FolderID)
+		if err != nil {
+			return models.Folder{}, err
+		}
+		for _, child := range children {
+			level, err := folderAccess(tx, child, ownerID)
+			if err != nil {
+				return models.Folder{}, err
+			}
+			if level >= accessView {
+				subtree = append(subtree, child)
+			}
+		}
+	}
+
+	var root models.Folder
+	copies := map[int]models.Folder{}
+	for i, f := range subtree {
+		target := models.Folder{ParentID: parentID, Name: name, FullPath: childPath(parentPath, name)}
+		if i > 0 {
+			parent := copies[*f.ParentID]
+			target = models.Folder{ParentID: &parent.FolderID, Name: f.Name, FullPath: childPath(parent.FullPath, f.Name)}
+		}
+
+		created, err := scanFolder(tx.QueryRow(`
+			INSERT INTO Folders (owner_id, parent_id, name, full_path)
+			VALUES ($1, $2, $3, $4)
+			RETURNING `+folderColumns,
+			ownerID, target.ParentID, target.Name, target.FullPath))
+		if err != nil {
+			return models.Folder{}, err
+		}
+		copies[f.FolderID] = created
+		if i == 0 {
+			root = created
+		}
+	}
+
+	for _, f := range subtree {
+		files, err := folderFileIDs(tx, f.FolderID)
+		if err != nil {
+			return models.Folder{}, err
+		}
+		target := copies[f.FolderID]
+		for _, fileID := range files {
+			auth, err := fileAccess(tx, fileID, ownerID)
+			if err != nil {
+				return models.Folder{}, err
+			}
+			if auth.Level < accessDownload {
+				continue
+			}
+			_, err = copyFile(tx, fileID, ownerID, &target.FolderID, target.FullPath, "", withVersions)
+			if err != nil {
+				return models.Folder{}, err
+			}
+		}
+	}
+	return root, nil
+}
+
+// folderFileIDs returns the files directly in a folder, except trashed ones
+func folderFileIDs(tx *sql.Tx, folderID int) ([]int, error) {
+	rows, err := tx.Query("SELECT file_id FROM Files WHERE folder_id = $1 AND deleted_at IS NULL", folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteFolder moves every file below the folder to the trash and deletes
+// the folder with its subfolders. Restoring a file recreates its folders,
+// but not their shares, so a folder whose subtree is still shared, linked or
+// open to file requests is refused until those are removed.
+func DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	folder, ok := ownFolder(w, r)
+	if !ok {
+		return
+	}
+
+	err := withTx(func(tx *sql.Tx) error {
+		ids, err := subtreeFolders(tx, folder.FolderID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, id := range ids {
+			if err := folderUnshared(tx, id, now); err != nil {
+				return err
+			}
+		}
+		for _, id := range ids {
+			_, err := tx.Exec(`
+				UPDATE Files SET deleted_at = $1 WHERE folder_id = $2 AND deleted_at IS NULL
+			`, now, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		// subfolders first, files keep their full_path for restoring
+		for i := len(ids) - 1; i >= 0; i-- {
+			if _, err := tx.Exec("UPDATE Files SET folder_id = NULL WHERE folder_id = $1", ids[i]); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("DELETE FROM Folders WHERE folder_id = $1", ids[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == errFolderShared {
+		http.Error(w, "Folder is shared, remove its shares, links and file requests first", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to delete folder", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Folder deleted, its files moved to trash"})
+}
+
+var errFolderShared = errors.New("folder is shared")
+
+// folderUnshared returns errFolderShared if the folder has a grant, link or
+// file request that still works; deleting the folder would delete them
+func folderUnshared(tx *sql.Tx, folderID int, now time.Time) error {
+	var shared bool
+	err := tx.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM Folder_Users WHERE folder_id = $1 AND (expires_at IS NULL OR expires_at > $2))
+			OR EXISTS (SELECT 1 FROM Folder_Groups WHERE folder_id = $1 AND (expires_at IS NULL OR expires_at > $2))
+			OR EXISTS (SELECT 1 FROM ShareLinks WHERE folder_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > $2))
+			OR EXISTS (SELECT 1 FROM FileRequests WHERE folder_id = $1 AND revoked_at IS NULL AND (deadline IS NULL OR deadline > $2))
+	`, folderID, now).Scan(&shared)
+	if err != nil {
+		return err
+	}
+	if shared {
+		return errFolderShared
+	}
+	return nil
+}
+
+// GetFolderChildren lists the folders and files directly in a folder the
+// caller has access to; "root" lists the caller's top level
+func GetFolderChildren(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+
+	// the top level lists the caller's own folders and files, a shared
+	// folder those of its owner the caller has access to
+	parent := "f.folder_id IS NULL"
+	folderFilter := "parent_id IS NULL"
+	args := []interface{}{userID}
+	ownerID := userID
+	if mux.Vars(r)["folder_id"] != "root" {
+		auth, ok := authorizeFolder(w, r, accessView)
+		if !ok {
+			return
+		}
+		parent = "f.folder_id = $2"
+		folderFilter = "parent_id = $2"
+		ownerID = auth.OwnerID
+		args = []interface{}{ownerID, auth.FolderID}
+	}
+
+	rows, err := config.PostgresDB.Query(`
+		SELECT `+folderColumns+` FROM Folders
+		WHERE owner_id = $1 AND `+folderFilter+`
+		ORDER BY name
+	`, args...)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	all := []models.Folder{}
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			http.Error(w, "Row scan error", http.StatusInternalServerError)
+			return
+		}
+		all = append(all, folder)
+	}
+
+	files, err := listFiles("f.owner_id = $1 AND f.deleted_at IS NULL AND "+parent+" ORDER BY f.name", args...)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// a nearer grant may remove access to some of them
+	folders := all
+	if ownerID != userID {
+		folders = []models.Folder{}
+		for _, folder := range all {
+			level, err := folderAccess(config.PostgresDB, folder, userID)
+			if err != nil {
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			if level >= accessView {
+				folders = append(folders, folder)
+			}
+		}
+		visible := []models.FileMetadata{}
+		for _, file := range files {
+			auth, err := fileAccess(config.PostgresDB, file.FileID, userID)
+			if err != nil {
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			if auth.Level >= accessView {
+				visible = append(visible, file)
+			}
+		}
+		files = visible
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"folders": folders,
+		"files":   files,
+	})
+}
+
+// GetFolderTree returns all folders of the caller as nested children
+func GetFolderTree(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+
+	// parents sort before their children by path
+	rows, err := config.PostgresDB.Query(`
+		SELECT `+folderColumns+` FROM Folders WHERE owner_id = $1 ORDER BY full_path
+	`, userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	nodes := map[int]*models.Folder{}
+	var all []*models.Folder
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			http.Error(w, "Row scan error", http.StatusInternalServerError)
+			return
+		}
+		nodes[folder.FolderID] = &folder
+		all = append(all, &folder)
+	}
+
+	roots := []*models.Folder{}
+	for _, folder := range all {
+		if parent, ok := nodes[intOrZero(folder.ParentID)]; ok {
+			parent.Children = append(parent.Children, folder)
+		} else {
+			roots = append(roots, folder)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roots)
+}
+
+// MigrateFolders creates folders for the full_path of files stored before
+// folders existed and turns the placeholder files of type "folder" into
+// folders, moving their shares onto the folders. Placeholders with content
+// are placed like other files. It can be run again and returns the number of
+// files placed in a folder and of placeholders replaced. Files in the trash
+// are left where they are.
+func MigrateFolders() (placed, replaced int, err error) {
+	type pathFile struct {
+		fileID, ownerID int
+		name, fullPath  string
+		fileType        string
+	}
+	rows, err := config.PostgresDB.Query(`
+		SELECT file_id, owner_id, name, full_path, type FROM Files
+		WHERE folder_id IS NULL AND owner_id IS NOT NULL AND deleted_at IS NULL
+	`)
+	if err != nil {
+		return 0, 0, err
+	}
+	var files []pathFile
+	for rows.Next() {
+		var f pathFile
+		var name, fullPath, fileType sql.NullString
+		if err := rows.Scan(&f.fileID, &f.ownerID, &name, &fullPath, &fileType); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		f.name, f.fullPath, f.fileType = name.String, fullPath.String, fileType.String
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, f := range files {
+		// counted once the transaction is committed
+		var isPlaced, isReplaced bool
+		err := withTx(func(tx *sql.Tx) error {
+			names, err := splitPath(f.fullPath)
+			if err != nil {
+				// unusable paths stay at the top level
+				names = nil
+			}
+			if f.fileType == "folder" && validFolderName(f.name) {
+				// a placeholder with content is kept as a file
+				var hasContent bool
+				err := tx.QueryRow(`
+					SELECT EXISTS (SELECT 1 FROM Files WHERE file_id = $1 AND mongo_file_id IS NOT NULL)
+						OR EXISTS (SELECT 1 FROM FileVersions WHERE file_id = $1 AND mongo_file_id IS NOT NULL)
+				`, f.fileID).Scan(&hasContent)
+				if err != nil {
+					return err
+				}
+				if !hasContent {
+					folderID, _, err := ensureFolder(tx, f.ownerID, "/"+strings.Join(append(names, f.name), "/"))
+					if err != nil {
+						return err
+					}
+					if err := moveGrantsToFolder(tx, f.fileID, *folderID); err != nil {
+						return err
+					}
+					isReplaced = true
+					return purgeFile(tx, f.fileID)
+				}
+			}
+
+			folderID, path, err := ensureFolder(tx, f.ownerID, "/"+strings.Join(names, "/"))
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("UPDATE Files SET folder_id = $1, full_path = $2 WHERE file_id = $3", folderID, path, f.fileID)
+			isPlaced = folderID != nil
+			return err
+		})
+		if err != nil {
+			return placed, replaced, err
+		}
+		if isPlaced {
+			placed++
+		}
+		if isReplaced {
+			replaced++
+		}
+	}
+	return placed, replaced, nil
+}
+
+// moveGrantsToFolder turns the shares of a placeholder file into shares of
+// the folder that replaces it, shares the folder has already are kept
+func moveGrantsToFolder(tx *sql.Tx, fileID, folderID int) error {
+	for _, principal := range []string{"user_id", "group_id"} {
+		item := aclItem{"Folder", "folder_id", folderID}
+		_, err := tx.Exec(
+			"INSERT INTO "+item.principalTable(principal)+" (folder_id, "+principal+", access_id, expires_at) "+
+				"SELECT $1, "+principal+", access_id, expires_at FROM "+aclItem{"File", "file_id", fileID}.principalTable(principal)+" WHERE file_id = $2 "+
+				"ON CONFLICT (folder_id, "+principal+") DO NOTHING",
+			folderID, fileID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package handlers_test
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"backend/config"
+	"backend/handlers"
+)
+
+// legacyFile inserts a file the way it was stored before folders existed
+func legacyFile(t *testing.T, owner user, name, fileType, path string, trashed bool) int {
+	t.Helper()
+	var deletedAt interface{}
+	if trashed {
+		deletedAt = "2024-01-01 00:00:00"
+	}
+	var fileID int
+	err := config.PostgresDB.QueryRow(`
+		INSERT INTO Files (owner_id, name, type, full_path, deleted_at) VALUES ($1, $2, $3, $4, $5)
+		RETURNING file_id
+	`, owner.id, name, fileType, path, deletedAt).Scan(&fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileID
+}
+
+func TestMigrateFolders(t *testing.T) {
+	alice, bob := newUser(t), newUser(t)
+	fileID := legacyFile(t, alice, "notes.txt", "text/plain", "/docs/2024", false)
+	trashedID := legacyFile(t, alice, "old.txt", "text/plain", "/archive", true)
+	placeholderID := legacyFile(t, alice, "photos", "folder", "/docs", false)
+	readBody(t, share(t, alice, fmt.Sprintf("files/%d", placeholderID), bob.id, "view"), http.StatusCreated)
+
+	placed, replaced, err := handlers.MigrateFolders()
+	if err != nil || placed != 1 || replaced != 1 {
+		t.Fatalf("MigrateFolders = %d, %d, %v, want 1, 1", placed, replaced, err)
+	}
+
+	var path string
+	err = config.PostgresDB.QueryRow(`
+		SELECT d.full_path FROM Files f JOIN Folders d ON d.folder_id = f.folder_id WHERE f.file_id = $1
+	`, fileID).Scan(&path)
+	if err != nil || path != "/docs/2024" {
+		t.Errorf("notes.txt is in %q, %v", path, err)
+	}
+
+	var folderID sql.NullInt64
+	if err := config.PostgresDB.QueryRow("SELECT folder_id FROM Files WHERE file_id = $1", trashedID).Scan(&folderID); err != nil || folderID.Valid {
+		t.Errorf("trashed file moved to folder %v, %v", folderID, err)
+	}
+	var archives int
+	config.PostgresDB.QueryRow("SELECT COUNT(*) FROM Folders WHERE owner_id = $1 AND name = 'archive'", alice.id).Scan(&archives)
+	if archives != 0 {
+		t.Error("a folder was created for a trashed file")
+	}
+
+	var photosID int
+	err = config.PostgresDB.QueryRow("SELECT folder_id FROM Folders WHERE owner_id = $1 AND full_path = '/docs/photos'", alice.id).Scan(&photosID)
+	if err != nil {
+		t.Fatalf("placeholder was not replaced: %v", err)
+	}
+	readBody(t, request(t, "GET", fmt.Sprintf("/api/folders/%d", photosID), bob.token, nil, nil), http.StatusOK)
+
+	if placed, replaced, err := handlers.MigrateFolders(); err != nil || placed != 0 || replaced != 0 {
+		t.Errorf("second MigrateFolders = %d, %d, %v, want 0, 0", placed, replaced, err)
+	}
+}
+
+// folderOf returns the path of the folder a file is in
+func folderOf(t *testing.T, fileID int) string {
+	t.Helper()
+	var path sql.NullString
+	err := config.PostgresDB.QueryRow(`
+		SELECT d.full_path FROM Files f LEFT JOIN Folders d ON d.folder_id = f.folder_id WHERE f.file_id = $1
+	`, fileID).Scan(&path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path.String
+}
+
+func TestMoveFileOfAnotherOwner(t *testing.T) {
+	alice, bob := newUser(t), newUser(t)
+	teamID := createFolder(t, alice, 0, "team")
+	doneID := createFolder(t, alice, teamID, "done")
+	privateID := createFolder(t, alice, 0, "private")
+	fileID := uploadFileInto(t, alice, teamID, "task.txt", "todo")
+	move := fmt.Sprintf("/api/files/%d/move", fileID)
+
+	readBody(t, share(t, alice, fmt.Sprintf("folders/%d", teamID), bob.id, "full_control"), http.StatusCreated)
+	readBody(t, share(t, alice, fmt.Sprintf("folders/%d", privateID), bob.id, "view"), http.StatusCreated)
+
+	readBody(t, request(t, "POST", move, bob.token, strings.NewReader(`{"full_path":"/bob"}`), nil), http.StatusBadRequest)
+	readBody(t, request(t, "POST", move, bob.token, strings.NewReader(fmt.Sprintf(`{"folder_id":%d}`, privateID)), nil), http.StatusForbidden)
+	readBody(t, request(t, "POST", move, bob.token, strings.NewReader(fmt.Sprintf(`{"folder_id":%d}`, doneID)), nil), http.StatusOK)
+	if path := folderOf(t, fileID); path != "/team/done" {
+		t.Errorf("moved to %q", path)
+	}
+
+	// the owner may still create folders by path
+	readBody(t, request(t, "POST", move, alice.token, strings.NewReader(`{"full_path":"/archive/2024"}`), nil), http.StatusOK)
+	if path := folderOf(t, fileID); path != "/archive/2024" {
+		t.Errorf("moved to %q", path)
+	}
+	var bobs int
+	config.PostgresDB.QueryRow("SELECT COUNT(*) FROM Folders WHERE owner_id = $1 AND name = 'bob'", alice.id).Scan(&bobs)
+	if bobs != 0 {
+		t.Error("a move by bob created a folder of alice")
+	}
+}
+
+func TestCopySharedFileAndFolder(t *testing.T) {
+	alice, bob := newUser(t), newUser(t)
+	teamID := createFolder(t, alice, 0, "team")
+	hiddenID := createFolder(t, alice, teamID, "hidden")
+	fileID := uploadFileInto(t, alice, teamID, "plan.txt", "the plan")
+	secretID := uploadFileInto(t, alice, teamID, "secret.txt", "not for bob")
+	uploadFileInto(t, alice, hiddenID, "deep.txt", "not for bob either")
+	bobsID := createFolder(t, bob, 0, "mine")
+	copyFile := fmt.Sprintf("/api/files/%d/copy", fileID)
+
+	readBody(t, share(t, alice, fmt.Sprintf("folders/%d", teamID), bob.id, "view"), http.StatusCreated)
+	readBody(t, request(t, "POST", copyFile, bob.token, strings.NewReader(fmt.Sprintf(`{"folder_id":%d}`, bobsID)), nil), http.StatusForbidden)
+
+	readBody(t, share(t, alice, fmt.Sprintf("folders/%d", teamID), bob.id, "download"), http.StatusCreated)
+	readBody(t, share(t, alice, fmt.Sprintf("files/%d", secretID), bob.id, ""), http.StatusCreated)
+	readBody(t, share(t, alice, fmt.Sprintf("folders/%d", hiddenID), bob.id, ""), http.StatusCreated)
+
+	// a copy goes to the caller's own folders only
+	readBody(t, request(t, "POST", copyFile, bob.token, strings.NewReader(fmt.Sprintf(`{"folder_id":%d}`, teamID)), nil), http.StatusNotFound)
+
+	var copied struct {
+		FileID int `json:"file_id"`
+	}
+	decode(t, request(t, "POST", copyFile, bob.token, strings.NewReader(fmt.Sprintf(`{"folder_id":%d,"name":"my plan.txt"}`, bobsID)), nil), http.StatusCreated, &copied)
+	if blobKey(t, copied.FileID) != blobKey(t, fileID) || folderOf(t, copied.FileID) != "/mine" {
+		t.Errorf("copy in %q refers to another blob", folderOf(t, copied.FileID))
+	}
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/files/%d", copied.FileID), bob.token, nil, nil), http.StatusOK)
+	readBody(t, request(t, "GET", fmt.Sprintf("/api/files/%d", fileID), alice.token, nil, nil), http.StatusOK)
+
+	// items bob cannot see are left out of a folder copy
+	var folder struct {
+		FolderID int `json:"folder_id"`
+		OwnerID  int `json:"owner_id"`
+	}
+	copyFolder := fmt.Sprintf("/api/folders/%d/copy", teamID)
+	decode(t, request(t, "POST", copyFolder, bob.token, strings.NewReader(fmt.Sprintf(`{"parent_id":%d}`, bobsID)), nil), http.StatusCreated, &folder)
+	if folder.OwnerID != bob.id {
+		t.Errorf("folder copy owned by %d", folder.OwnerID)
+	}
+	var names []string
+	rows, err := config.PostgresDB.Query(`
+		SELECT name FROM Files WHERE folder_id = $1 AND owner_id = $2
+		UNION ALL SELECT name FROM Folders WHERE parent_id = $1
+	`, folder.FolderID, bob.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		names = append(names, name)
+	}
+	rows.Close()
+	if len(names) != 1 || names[0] != "plan.txt" {
+		t.Errorf("folder copy has %v, want only plan.txt", names)
+	}
+}
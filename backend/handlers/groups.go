@@ -15,7 +15,9 @@ type Group struct {
 	ID          int    `json:"group_id"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
-	ParentID    *int   `json:"parent_id"` // nullable
+	ParentID    *int   `json:"parent_id"`   // nullable
+	QuotaBytes  *int64 `json:"quota_bytes"` // for the group and its subgroups, 0 is unlimited
+	QuotaFiles  *int64 `json:"quota_files"`
 }
 type GroupTreeNode struct {
 	ID          int              `json:"group_id"`
@@ -29,6 +31,9 @@ type GroupTreeNode struct {
 /*
 name: string
 description: string
+parent_id: int | null
+quota_bytes: int | null
+quota_files: int | null
 */
 func CreateGroup(w http.ResponseWriter, r *http.Request) {
 	var group Group
@@ -37,8 +42,8 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `INSERT INTO Groups (name, description, parent_id) VALUES ($1, $2, $3) RETURNING group_id`
-	err := config.PostgresDB.QueryRow(query, group.Name, group.Description, group.ParentID).Scan(&group.ID)
+	query := `INSERT INTO Groups (name, description, parent_id, quota_bytes, quota_files) VALUES ($1, $2, $3, $4, $5) RETURNING group_id`
+	err := config.PostgresDB.QueryRow(query, group.Name, group.Description, group.ParentID, group.QuotaBytes, group.QuotaFiles).Scan(&group.ID)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
@@ -49,7 +54,7 @@ func CreateGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetGroups(w http.ResponseWriter, r *http.Request) {
-	rows, err := config.PostgresDB.Query("SELECT group_id, name, description, parent_id FROM Groups")
+	rows, err := config.PostgresDB.Query("SELECT group_id, name, description, parent_id, quota_bytes, quota_files FROM Groups")
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
@@ -59,7 +64,7 @@ func GetGroups(w http.ResponseWriter, r *http.Request) {
 	var groups []Group
 	for rows.Next() {
 		var group Group
-		if err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.ParentID); err != nil {
+		if err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.ParentID, &group.QuotaBytes, &group.QuotaFiles); err != nil {
 			http.Error(w, "Row scan error", http.StatusInternalServerError)
 			return
 		}
@@ -82,10 +87,10 @@ func GetGroupByID(w http.ResponseWriter, r *http.Request) {
 
 	var group Group
 	err = config.PostgresDB.QueryRow(`
-		SELECT group_id, name, description, parent_id
+		SELECT group_id, name, description, parent_id, quota_bytes, quota_files
 		FROM Groups
 		WHERE group_id = $1
-	`, groupID).Scan(&group.ID, &group.Name, &group.Description, &group.ParentID)
+	`, groupID).Scan(&group.ID, &group.Name, &group.Description, &group.ParentID, &group.QuotaBytes, &group.QuotaFiles)
 
 	if err == sql.ErrNoRows {
 		http.Error(w, "Group not found", http.StatusNotFound)
@@ -102,6 +107,10 @@ func GetGroupByID(w http.ResponseWriter, r *http.Request) {
 /*
 name: string
 description: string
+parent_id: int | null
+quota_bytes: int, optional, 0 is unlimited
+quota_files: int, optional, 0 is unlimited
+quotas that are not sent are kept
 */
 func UpdateGroup(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -114,8 +123,9 @@ func UpdateGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_, err := config.PostgresDB.Exec(
-		"UPDATE Groups SET name = $1, description = $2, parent_id = $3 WHERE group_id = $4",
-		group.Name, group.Description, group.ParentID, groupID,
+		"UPDATE Groups SET name = $1, description = $2, parent_id = $3, "+
+			"quota_bytes = COALESCE($4, quota_bytes), quota_files = COALESCE($5, quota_files) WHERE group_id = $6",
+		group.Name, group.Description, group.ParentID, group.QuotaBytes, group.QuotaFiles, groupID,
 	)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -0,0 +1,182 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backend/config"
+	"backend/routes"
+)
+
+// the handlers run against the embedded SQLite database and local blobs
+var server *httptest.Server
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "handlers-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("DB_BACKEND", "sqlite")
+	os.Setenv("SQLITE_PATH", filepath.Join(dir, "filestorage.db"))
+	os.Setenv("STORAGE_PATH", filepath.Join(dir, "blobs"))
+	os.Setenv("UPLOAD_PATH", filepath.Join(dir, "uploads"))
+	config.LoadSettings()
+	config.ConnectDB()
+
+	server = httptest.NewServer(routes.RegisterRoutes())
+	code := m.Run()
+	server.Close()
+	config.PostgresDB.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+type user struct {
+	id    int
+	token string
+}
+
+var users int
+
+// newUser registers and logs in a user with a unique login
+func newUser(t *testing.T) user {
+	t.Helper()
+	users++
+	login := fmt.Sprintf("user%d", users)
+	body := fmt.Sprintf(`{"login":%q,"password":"p","mail":"%s@test","name":"n","surname":"s"}`, login, login)
+
+	var registered struct {
+		UserID int `json:"user_id"`
+	}
+	resp := request(t, "POST", "/register", "", strings.NewReader(body), nil)
+	decode(t, resp, http.StatusCreated, &registered)
+
+	var loggedIn struct {
+		Token string `json:"token"`
+	}
+	resp = request(t, "POST", "/login", "", strings.NewReader(fmt.Sprintf(`{"login":%q,"password":"p"}`, login)), nil)
+	decode(t, resp, http.StatusOK, &loggedIn)
+	return user{id: registered.UserID, token: loggedIn.Token}
+}
+
+func request(t *testing.T, method, path, token string, body io.Reader, header http.Header) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// decode checks the status and reads a JSON response into v
+func decode(t *testing.T, resp *http.Response, status int, v interface{}) {
+	t.Helper()
+	body := readBody(t, resp, status)
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		t.Fatalf("%s: %v", body, err)
+	}
+}
+
+func readBody(t *testing.T, resp *http.Response, status int) string {
+	t.Helper()
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: %d %s, want %d", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, body, status)
+	}
+	return string(body)
+}
+
+func upload(t *testing.T, u user, name, content string) *http.Response {
+	t.Helper()
+	return uploadInto(t, u, 0, name, content)
+}
+
+// uploadInto uploads to a folder, 0 for the top level
+func uploadInto(t *testing.T, u user, folderID int, name, content string) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	if folderID != 0 {
+		form.WriteField("folder_id", fmt.Sprint(folderID))
+	} else {
+		form.WriteField("full_path", "")
+	}
+	part, _ := form.CreateFormFile("file", name)
+	part.Write([]byte(content))
+	form.Close()
+	return request(t, "POST", "/api/files/upload", u.token, &body, http.Header{"Content-Type": {form.FormDataContentType()}})
+}
+
+func uploadFile(t *testing.T, u user, name, content string) int {
+	t.Helper()
+	var uploaded struct {
+		FileID int `json:"file_id"`
+	}
+	decode(t, upload(t, u, name, content), http.StatusCreated, &uploaded)
+	return uploaded.FileID
+}
+
+func uploadFileInto(t *testing.T, u user, folderID int, name, content string) int {
+	t.Helper()
+	var uploaded struct {
+		FileID int `json:"file_id"`
+	}
+	decode(t, uploadInto(t, u, folderID, name, content), http.StatusCreated, &uploaded)
+	return uploaded.FileID
+}
+
+// createFolder creates a folder below parentID, 0 for the top level
+func createFolder(t *testing.T, u user, parentID int, name string) int {
+	t.Helper()
+	parent := "null"
+	if parentID != 0 {
+		parent = fmt.Sprint(parentID)
+	}
+	var folder struct {
+		FolderID int `json:"folder_id"`
+	}
+	body := fmt.Sprintf(`{"name":%q,"parent_id":%s}`, name, parent)
+	decode(t, request(t, "POST", "/api/folders", u.token, strings.NewReader(body), nil), http.StatusCreated, &folder)
+	return folder.FolderID
+}
+
+// share grants access by its name to a user on "files/{id}" or
+// "folders/{id}", an empty access removes inherited access
+func share(t *testing.T, owner user, item string, userID int, access string) *http.Response {
+	t.Helper()
+	accessID := "null"
+	if access != "" {
+		accessID = fmt.Sprint(accessLevel(t, access))
+	}
+	body := fmt.Sprintf(`{"user_id":%d,"access_id":%s}`, userID, accessID)
+	return request(t, "POST", "/api/"+item+"/share/user", owner.token, strings.NewReader(body), nil)
+}
+
+func accessLevel(t *testing.T, name string) int {
+	t.Helper()
+	var accessID int
+	if err := config.PostgresDB.QueryRow("SELECT access_id FROM Access WHERE name = $1", name).Scan(&accessID); err != nil {
+		t.Fatal(err)
+	}
+	return accessID
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+)
+
+// notify adds a notification for the user, e.g. when a share of their file
+// expired
+func notify(tx *sql.Tx, userID int, kind, message string, fileID, folderID *int) error {
+	_, err := tx.Exec(`
+		INSERT INTO Notifications (user_id, type, message, file_id, folder_id, create_date)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, userID, kind, message, fileID, folderID, time.Now().UTC())
+	return err
+}
+
+// GetNotifications lists the caller's notifications, newest first
+func GetNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := config.PostgresDB.Query(`
+		SELECT notification_id, type, message, file_id, folder_id, create_date, read_at
+		FROM Notifications
+		WHERE user_id = $1
+		ORDER BY create_date DESC, notification_id DESC
+	`, userID)
+	if err != nil {
+		http.Error(w, "Database query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		var n models.Notification
+		err := rows.Scan(&n.NotificationID, &n.Type, &n.Message, &n.FileID, &n.FolderID, &n.CreateDate, &n.ReadAt)
+		if err != nil {
+			http.Error(w, "Row scan error", http.StatusInternalServerError)
+			return
+		}
+		notifications = append(notifications, n)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+func MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+
+	res, err := config.PostgresDB.Exec(`
+		UPDATE Notifications SET read_at = COALESCE(read_at, $1)
+		WHERE notification_id = $2 AND user_id = $3
+	`, time.Now().UTC(), mux.Vars(r)["notification_id"], userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Notification not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Notification marked as read",
+	})
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"backend/config"
+	"backend/middleware"
+)
+
+// Quota is a limit on the files owned by a user, or by all users of a
+// group and its subgroups, with the current usage. A file uses the size of
+// each distinct blob it or its versions refer to. Limits of nil or 0 mean
+// no limit.
+type Quota struct {
+	Scope     string `json:"scope"` // "user" or "group"
+	UserID    int    `json:"user_id,omitempty"`
+	GroupID   int    `json:"group_id,omitempty"`
+	GroupName string `json:"group_name,omitempty"`
+	MaxBytes  *int64 `json:"max_bytes"`
+	MaxFiles  *int64 `json:"max_files"`
+	UsedBytes int64  `json:"used_bytes"`
+	UsedFiles int64  `json:"used_files"`
+}
+
+// quotaError is a change that does not fit into a quota
+type quotaError struct {
+	message string
+}
+
+func (e *quotaError) Error() string {
+	return e.message
+}
+
+// queryer is a *sql.DB or a *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func limited(limit *int64) bool {
+	return limit != nil && *limit > 0
+}
+
+func (q Quota) name() string {
+	if q.Scope == "group" {
+		return fmt.Sprintf("Group %q", q.GroupName)
+	}
+	return "User"
+}
+
+// files owned by the users in the owner subquery, which uses $1
+const quotaUsageQuery = `
+	SELECT COUNT(DISTINCT file_id), COALESCE(SUM(size), 0) FROM (
+		SELECT file_id, mongo_file_id, size FROM Files WHERE owner_id IN (%[1]s)
+		UNION
+		SELECT v.file_id, v.mongo_file_id, v.size
+		FROM FileVersions v
+		JOIN Files f ON f.file_id = v.file_id
+		WHERE f.owner_id IN (%[1]s)
+	) blobs
+`
+
+// loadQuotas returns the user's own quota and the quotas of the user's group
+// and its parent groups that have a limit
+func loadQuotas(q queryer, userID int) ([]Quota, error) {
+	user := Quota{Scope: "user", UserID: userID}
+	var groupID sql.NullInt64
+	err := q.QueryRow(`
+		SELECT quota_bytes, quota_files, group_id FROM Users WHERE user_id = $1
+	`, userID).Scan(&user.MaxBytes, &user.MaxFiles, &groupID)
+	if err != nil {
+		return nil, err
+	}
+	err = q.QueryRow(fmt.Sprintf(quotaUsageQuery, "$1"), userID).Scan(&user.UsedFiles, &user.UsedBytes)
+	if err != nil {
+		return nil, err
+	}
+	quotas := []Quota{user}
+
+	// walk up to the root group, a cycle in parent_id ends the walk
+	seen := map[int64]bool{}
+	for groupID.Valid && !seen[groupID.Int64] {
+		seen[groupID.Int64] = true
+		group := Quota{Scope: "group", GroupID: int(groupID.Int64)}
+		err := q.QueryRow(`
+			SELECT name, quota_bytes, quota_files, parent_id FROM Groups WHERE group_id = $1
+		`, groupID.Int64).Scan(&group.GroupName, &group.MaxBytes, &group.MaxFiles, &groupID)
+		if err == sql.ErrNoRows {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if !limited(group.MaxBytes) && !limited(group.MaxFiles) {
+			continue
+		}
+
+		owners := "SELECT user_id FROM Users WHERE group_id IN (SELECT group_id FROM get_group_tree($1))"
+		err = q.QueryRow(fmt.Sprintf(quotaUsageQuery, owners), group.GroupID).Scan(&group.UsedFiles, &group.UsedBytes)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, group)
+	}
+	return quotas, nil
+}
+
+// quotaRoom returns how many bytes still fit into all quotas and the quota
+// that bounds them, nil if none does, or a quotaError if newFiles more files
+// do not fit
+func quotaRoom(quotas []Quota, newFiles int64) (int64, *Quota, error) {
+	var bound *Quota
+	for i, q := range quotas {
+		if limited(q.MaxFiles) && q.UsedFiles+newFiles > *q.MaxFiles {
+			return 0, nil, &quotaError{q.name() + " file count quota exceeded"}
+		}
+		if !limited(q.MaxBytes) {
+			continue
+		}
+		left := *q.MaxBytes - q.UsedBytes
+		if left <= 0 {
+			return 0, nil, &quotaError{q.name() + " storage quota exceeded"}
+		}
+		if bound == nil || left < *bound.MaxBytes-bound.UsedBytes {
+			bound = &quotas[i]
+		}
+	}
+	if bound == nil {
+		return -1, nil, nil
+	}
+	return *bound.MaxBytes - bound.UsedBytes, bound, nil
+}
+
+// enforceQuotas fails if the changes made in tx grew the usage of a quota
+// past its limit; usage that was already over a lowered limit may shrink.
+// The user and group rows stay locked until tx ends, so concurrent changes
+// are checked one after the other and see each other's files.
+func enforceQuotas(tx *sql.Tx, userID int, before []Quota) error {
+	if err := lockQuotas(tx, userID); err != nil {
+		return err
+	}
+	after, err := loadQuotas(tx, userID)
+	if err != nil {
+		return err
+	}
+	for _, q := range after {
+		var prev Quota
+		for _, b := range before {
+			if b.Scope == q.Scope && b.GroupID == q.GroupID {
+				prev = b
+			}
+		}
+		if limited(q.MaxFiles) && q.UsedFiles > *q.MaxFiles && q.UsedFiles > prev.UsedFiles {
+			return &quotaError{q.name() + " file count quota exceeded"}
+		}
+		if limited(q.MaxBytes) && q.UsedBytes > *q.MaxBytes && q.UsedBytes > prev.UsedBytes {
+			return &quotaError{q.name() + " storage quota exceeded"}
+		}
+	}
+	return nil
+}
+
+// lockQuotas locks the rows of the user and of the user's groups up to the
+// root. NO KEY UPDATE does not conflict with the key share locks taken by
+// inserting files that refer to the user.
+func lockQuotas(tx *sql.Tx, userID int) error {
+	var groupID sql.NullInt64
+	err := tx.QueryRow("SELECT group_id FROM Users WHERE user_id = $1 FOR NO KEY UPDATE", userID).Scan(&groupID)
+	if err != nil {
+		return err
+	}
+
+	seen := map[int64]bool{}
+	for groupID.Valid && !seen[groupID.Int64] {
+		seen[groupID.Int64] = true
+		err := tx.QueryRow("SELECT parent_id FROM Groups WHERE group_id = $1 FOR NO KEY UPDATE", groupID.Int64).Scan(&groupID)
+		if err == sql.ErrNoRows {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadLimit bounds an upload by the role's size limit and by the room
+// left in the user's quotas
+type uploadLimit struct {
+	maxSize int64 // 0 is no limit
+	room    int64 // -1 is no limit
+	bound   *Quota
+	quotas  []Quota // usage before the upload
+}
+
+// newUploadLimit bounds an upload by userID into a file of ownerID, whose
+// quotas are charged. It fails with a quotaError if the quotas have no room
+// for newFiles more files.
+func newUploadLimit(userID, ownerID int, newFiles int64) (uploadLimit, error) {
+	maxSize, err := maxUploadSize(userID)
+	if err != nil {
+		return uploadLimit{}, err
+	}
+	quotas, err := loadQuotas(config.PostgresDB, ownerID)
+	if err != nil {
+		return uploadLimit{}, err
+	}
+	room, bound, err := quotaRoom(quotas, newFiles)
+	if err != nil {
+		return uploadLimit{}, err
+	}
+	return uploadLimit{maxSize: maxSize, room: room, bound: bound, quotas: quotas}, nil
+}
+
+// size is the largest allowed upload, 0 if there is no limit
+func (l uploadLimit) size() int64 {
+	if l.room >= 0 && (l.maxSize == 0 || l.room < l.maxSize) {
+		return l.room
+	}
+	return l.maxSize
+}
+
+// check returns the error for an upload of length bytes, nil if it fits
+func (l uploadLimit) check(length int64) error {
+	if l.maxSize > 0 && length > l.maxSize {
+		return errUploadTooLarge
+	}
+	if l.room >= 0 && length > l.room {
+		return &quotaError{l.bound.name() + " storage quota exceeded"}
+	}
+	return nil
+}
+
+// GetQuota returns the quotas that apply to the caller with their usage
+func GetQuota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+
+	quotas, err := loadQuotas(config.PostgresDB, userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quotas)
+}
@@ -0,0 +1,24 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"backend/config"
+)
+
+func TestQuotaRejectsUpload(t *testing.T) {
+	alice := newUser(t)
+	if _, err := config.PostgresDB.Exec("UPDATE Users SET quota_bytes = 10 WHERE user_id = $1", alice.id); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadFile(t, alice, "a.txt", "123456")
+	readBody(t, upload(t, alice, "b.txt", "123456"), http.StatusInsufficientStorage)
+
+	var files []struct{}
+	decode(t, request(t, "GET", "/api/files", alice.token, nil, nil), http.StatusOK, &files)
+	if len(files) != 1 {
+		t.Errorf("%d files after a rejected upload, want 1", len(files))
+	}
+}
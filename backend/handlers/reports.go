@@ -0,0 +1,456 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/config"
+)
+
+// Storage reports for admins. Every report is a list of rows, returned as
+// JSON or, with ?format=csv, as CSV with the JSON field names as header.
+// Bytes of a file are the sizes of the distinct blobs it and its versions
+// refer to, the same as for quotas. Files in the trash still count, as they
+// do for quotas, and file lists mark them as deleted.
+
+// every distinct blob of every file with the file's owner
+const fileBlobsQuery = `
+	SELECT f.file_id, f.owner_id, f.mongo_file_id, f.size FROM Files f
+	UNION
+	SELECT f.file_id, f.owner_id, v.mongo_file_id, v.size
+	FROM FileVersions v
+	JOIN Files f ON f.file_id = v.file_id
+`
+
+type userUsageRow struct {
+	UserID int    `json:"user_id"`
+	Login  string `json:"login"`
+	Files  int64  `json:"files"`
+	Bytes  int64  `json:"bytes"`
+}
+
+func loadUserUsage() ([]userUsageRow, error) {
+	rows, err := config.PostgresDB.Query(`
+		SELECT u.user_id, u.login, COUNT(DISTINCT fb.file_id), COALESCE(SUM(fb.size), 0)
+		FROM Users u
+		LEFT JOIN (` + fileBlobsQuery + `) fb ON fb.owner_id = u.user_id
+		GROUP BY u.user_id, u.login
+		ORDER BY 4 DESC, u.user_id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := []userUsageRow{}
+	for rows.Next() {
+		var row userUsageRow
+		var login sql.NullString
+		if err := rows.Scan(&row.UserID, &login, &row.Files, &row.Bytes); err != nil {
+			return nil, err
+		}
+		row.Login = login.String
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+// ReportUsageByUser lists files and bytes owned by each user, largest first
+func ReportUsageByUser(w http.ResponseWriter, r *http.Request) {
+	report, err := loadUserUsage()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	writeReport(w, r, "usage-by-user", report)
+}
+
+type groupUsageRow struct {
+	GroupID  int    `json:"group_id"`
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id"`
+	Depth    int    `json:"depth"`
+	Users    int64  `json:"users"`
+	Files    int64  `json:"files"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// ReportUsageByGroup lists usage of every group together with its
+// subgroups, in the order of get_group_tree
+func ReportUsageByGroup(w http.ResponseWriter, r *http.Request) {
+	rows, err := config.PostgresDB.Query(`
+		SELECT group_id, name, parent_id, depth FROM get_group_tree($1)
+	`, sql.NullInt32{})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	report := []groupUsageRow{}
+	index := map[int]int{}
+	for rows.Next() {
+		var row groupUsageRow
+		if err := rows.Scan(&row.GroupID, &row.Name, &row.ParentID, &row.Depth); err != nil {
+			http.Error(w, "Row scan error", http.StatusInternalServerError)
+			return
+		}
+		index[row.GroupID] = len(report)
+		report = append(report, row)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	users, err := loadUserUsage()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	groupOf := map[int]int{}
+	err = eachReportRow("SELECT user_id, group_id FROM Users WHERE group_id IS NOT NULL", func(rows *sql.Rows) error {
+		var userID, groupID int
+		err := rows.Scan(&userID, &groupID)
+		groupOf[userID] = groupID
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// a user counts for the own group and every group above it
+	for _, user := range users {
+		groupID, ok := groupOf[user.UserID]
+		seen := map[int]bool{}
+		for ok && !seen[groupID] {
+			seen[groupID] = true
+			i, found := index[groupID]
+			if !found {
+				break
+			}
+			report[i].Users++
+			report[i].Files += user.Files
+			report[i].Bytes += user.Bytes
+			ok = report[i].ParentID != nil
+			if ok {
+				groupID = *report[i].ParentID
+			}
+		}
+	}
+
+	writeReport(w, r, "usage-by-group", report)
+}
+
+type typeUsageRow struct {
+	Extension string `json:"extension"`
+	Files     int64  `json:"files"`
+	Bytes     int64  `json:"bytes"`
+}
+
+type ageUsageRow struct {
+	Age   string `json:"age"`
+	Files int64  `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// age buckets by the upload date of the file
+var ageBuckets = []struct {
+	name string
+	days int
+}{
+	{"under 30 days", 30},
+	{"30-90 days", 90},
+	{"90-365 days", 365},
+	{"over 1 year", -1},
+}
+
+// ReportUsageByType lists usage by file extension, largest first
+func ReportUsageByType(w http.ResponseWriter, r *http.Request) {
+	byExtension := map[string]*typeUsageRow{}
+	err := eachFileUsage(func(name string, created time.Time, files, bytes int64) {
+		ext := strings.ToLower(filepath.Ext(name))
+		if byExtension[ext] == nil {
+			byExtension[ext] = &typeUsageRow{Extension: ext}
+		}
+		byExtension[ext].Files += files
+		byExtension[ext].Bytes += bytes
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	report := []typeUsageRow{}
+	for _, row := range byExtension {
+		report = append(report, *row)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Bytes != report[j].Bytes {
+			return report[i].Bytes > report[j].Bytes
+		}
+		return report[i].Extension < report[j].Extension
+	})
+	writeReport(w, r, "usage-by-type", report)
+}
+
+// ReportUsageByAge lists usage by how long ago files were uploaded
+func ReportUsageByAge(w http.ResponseWriter, r *http.Request) {
+	report := make([]ageUsageRow, len(ageBuckets))
+	for i, bucket := range ageBuckets {
+		report[i].Age = bucket.name
+	}
+
+	now := time.Now()
+	err := eachFileUsage(func(name string, created time.Time, files, bytes int64) {
+		days := int(now.Sub(created).Hours() / 24)
+		for i, bucket := range ageBuckets {
+			if days < bucket.days || bucket.days < 0 {
+				report[i].Files += files
+				report[i].Bytes += bytes
+				return
+			}
+		}
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	writeReport(w, r, "usage-by-age", report)
+}
+
+// eachFileUsage calls fn with every file and the bytes it uses
+func eachFileUsage(fn func(name string, created time.Time, files, bytes int64)) error {
+	return eachReportRow(`
+		SELECT f.name, f.create_date, COALESCE(SUM(fb.size), 0)
+		FROM Files f
+		JOIN (`+fileBlobsQuery+`) fb ON fb.file_id = f.file_id
+		GROUP BY f.file_id, f.name, f.create_date
+	`, func(rows *sql.Rows) error {
+		var name sql.NullString
+		var created time.Time
+		var bytes int64
+		if err := rows.Scan(&name, &created, &bytes); err != nil {
+			return err
+		}
+		fn(name.String, created, 1, bytes)
+		return nil
+	})
+}
+
+type fileReportRow struct {
+	FileID     int        `json:"file_id"`
+	Name       string     `json:"name"`
+	FullPath   string     `json:"full_path"`
+	OwnerID    *int       `json:"owner_id"`
+	Owner      string     `json:"owner"`
+	Size       int64      `json:"size"`
+	CreateDate time.Time  `json:"create_date"`
+	AccessDate *time.Time `json:"access_date"`
+	Deleted    bool       `json:"deleted"`
+}
+
+// files with the owner login, for the queries below
+const fileReportColumns = `
+	f.file_id, f.name, f.full_path, f.owner_id, u.login, COALESCE(f.size, 0), f.create_date, f.access_date,
+	f.deleted_at IS NOT NULL
+`
+
+func scanFileReport(rows *sql.Rows, extra ...interface{}) (fileReportRow, error) {
+	var row fileReportRow
+	var name, fullPath, owner sql.NullString
+	err := rows.Scan(append([]interface{}{
+		&row.FileID, &name, &fullPath, &row.OwnerID, &owner, &row.Size, &row.CreateDate, &row.AccessDate, &row.Deleted,
+	}, extra...)...)
+	row.Name, row.FullPath, row.Owner = name.String, fullPath.String, owner.String
+	return row, err
+}
+
+// ReportLargestFiles lists the ?limit (default 50) largest files
+func ReportLargestFiles(w http.ResponseWriter, r *http.Request) {
+	limit, ok := reportParam(w, r, "limit", 50)
+	if !ok {
+		return
+	}
+
+	report := []fileReportRow{}
+	err := eachReportRow(`
+		SELECT `+fileReportColumns+`
+		FROM Files f
+		LEFT JOIN Users u ON u.user_id = f.owner_id
+		ORDER BY f.size DESC, f.file_id
+		LIMIT $1
+	`, func(rows *sql.Rows) error {
+		row, err := scanFileReport(rows)
+		report = append(report, row)
+		return err
+	}, limit)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	writeReport(w, r, "largest-files", report)
+}
+
+// ReportStaleFiles lists files not downloaded in ?days (default 90) days,
+// counting files never downloaded from their upload, oldest first
+func ReportStaleFiles(w http.ResponseWriter, r *http.Request) {
+	days, ok := reportParam(w, r, "days", 90)
+	if !ok {
+		return
+	}
+	cutoff := time.Now().UTC().AddDate(0, 0, -days)
+
+	report := []fileReportRow{}
+	err := eachReportRow(`
+		SELECT `+fileReportColumns+`
+		FROM Files f
+		LEFT JOIN Users u ON u.user_id = f.owner_id
+		WHERE COALESCE(f.access_date, f.create_date) < $1
+		ORDER BY COALESCE(f.access_date, f.create_date), f.file_id
+	`, func(rows *sql.Rows) error {
+		row, err := scanFileReport(rows)
+		report = append(report, row)
+		return err
+	}, cutoff)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	writeReport(w, r, "stale-files", report)
+}
+
+type versionOverheadRow struct {
+	fileReportRow
+	Versions      int64 `json:"versions"`
+	TotalBytes    int64 `json:"total_bytes"`
+	OverheadBytes int64 `json:"overhead_bytes"` // held only by versions other than the current content
+}
+
+// ReportVersionOverhead lists files whose versions keep other content than
+// the current one, most overhead first
+func ReportVersionOverhead(w http.ResponseWriter, r *http.Request) {
+	report := []versionOverheadRow{}
+	err := eachReportRow(`
+		SELECT `+fileReportColumns+`,
+			(SELECT COUNT(*) FROM FileVersions v WHERE v.file_id = f.file_id),
+			fb.bytes
+		FROM Files f
+		LEFT JOIN Users u ON u.user_id = f.owner_id
+		JOIN (
+			SELECT file_id, SUM(size) AS bytes FROM (`+fileBlobsQuery+`) blobs GROUP BY file_id
+		) fb ON fb.file_id = f.file_id
+		WHERE fb.bytes > COALESCE(f.size, 0)
+		ORDER BY fb.bytes - COALESCE(f.size, 0) DESC, f.file_id
+	`, func(rows *sql.Rows) error {
+		var row versionOverheadRow
+		var err error
+		row.fileReportRow, err = scanFileReport(rows, &row.Versions, &row.TotalBytes)
+		row.OverheadBytes = row.TotalBytes - row.Size
+		report = append(report, row)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	writeReport(w, r, "version-overhead", report)
+}
+
+// reportParam reads a positive integer query parameter
+func reportParam(w http.ResponseWriter, r *http.Request, name string, def int) (int, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		http.Error(w, "Invalid "+name, http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+func eachReportRow(query string, fn func(rows *sql.Rows) error, args ...interface{}) error {
+	rows, err := config.PostgresDB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// writeReport writes a slice of structs as JSON, or as CSV if asked for
+// with ?format=csv
+func writeReport(w http.ResponseWriter, r *http.Request, name string, report interface{}) {
+	if r.URL.Query().Get("format") != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	rows := reflect.ValueOf(report)
+	header := csvHeader(rows.Type().Elem())
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%s.csv\"", name, time.Now().UTC().Format("20060102")))
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for i := 0; i < rows.Len(); i++ {
+		cw.Write(csvRecord(rows.Index(i)))
+	}
+	cw.Flush()
+}
+
+// csvHeader names the columns after the json tags, embedded structs inline
+func csvHeader(t reflect.Type) []string {
+	var header []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			header = append(header, csvHeader(field.Type)...)
+			continue
+		}
+		header = append(header, strings.Split(field.Tag.Get("json"), ",")[0])
+	}
+	return header
+}
+
+func csvRecord(v reflect.Value) []string {
+	var record []string
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if v.Type().Field(i).Anonymous {
+			record = append(record, csvRecord(field)...)
+			continue
+		}
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				record = append(record, "")
+				continue
+			}
+			field = field.Elem()
+		}
+		if t, ok := field.Interface().(time.Time); ok {
+			record = append(record, t.UTC().Format(time.RFC3339))
+		} else {
+			record = append(record, fmt.Sprint(field.Interface()))
+		}
+	}
+	return record
+}
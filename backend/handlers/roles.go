@@ -11,15 +11,17 @@ import (
 )
 
 type Role struct {
-	ID          int    `json:"role_id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Permissions []int  `json:"permissions"`
+	ID            int    `json:"role_id"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	MaxUploadSize *int64 `json:"max_upload_size"` // bytes, 0 is unlimited
+	Permissions   []int  `json:"permissions"`
 }
 
 /*
 name: string
 description: string
+max_upload_size: int | null
 */
 func CreateRole(w http.ResponseWriter, r *http.Request) {
 	var role Role
@@ -28,8 +30,8 @@ func CreateRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `INSERT INTO Roles (name, description) VALUES ($1, $2) RETURNING role_id`
-	err := config.PostgresDB.QueryRow(query, role.Name, role.Description).Scan(&role.ID)
+	query := `INSERT INTO Roles (name, description, max_upload_size) VALUES ($1, $2, $3) RETURNING role_id`
+	err := config.PostgresDB.QueryRow(query, role.Name, role.Description, role.MaxUploadSize).Scan(&role.ID)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
@@ -40,7 +42,7 @@ func CreateRole(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetRoles(w http.ResponseWriter, r *http.Request) {
-	rows, err := config.PostgresDB.Query("SELECT role_id, name, description FROM Roles")
+	rows, err := config.PostgresDB.Query("SELECT role_id, name, description, max_upload_size FROM Roles")
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
@@ -50,7 +52,7 @@ func GetRoles(w http.ResponseWriter, r *http.Request) {
 	var roles []Role
 	for rows.Next() {
 		var role Role
-		if err := rows.Scan(&role.ID, &role.Name, &role.Description); err != nil {
+		if err := rows.Scan(&role.ID, &role.Name, &role.Description, &role.MaxUploadSize); err != nil {
 			http.Error(w, "Row scan error", http.StatusInternalServerError)
 			return
 		}
@@ -93,11 +95,11 @@ func GetRole(w http.ResponseWriter, r *http.Request) {
 	// get role
 	var role Role
 	query := `
-		SELECT role_id, name, description
+		SELECT role_id, name, description, max_upload_size
 		FROM Roles
 		WHERE role_id = $1
 	`
-	err := config.PostgresDB.QueryRow(query, roleID).Scan(&role.ID, &role.Name, &role.Description)
+	err := config.PostgresDB.QueryRow(query, roleID).Scan(&role.ID, &role.Name, &role.Description, &role.MaxUploadSize)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Role not found", http.StatusNotFound)
@@ -141,6 +143,7 @@ func GetRole(w http.ResponseWriter, r *http.Request) {
 /*
 name: string
 description: string
+max_upload_size: int | null
 permissions: int[]
 */
 func UpdateRole(w http.ResponseWriter, r *http.Request) {
@@ -155,8 +158,8 @@ func UpdateRole(w http.ResponseWriter, r *http.Request) {
 
 	// update name and description
 	_, err := config.PostgresDB.Exec(
-		"UPDATE Roles SET name = $1, description = $2 WHERE role_id = $3",
-		role.Name, role.Description, roleID,
+		"UPDATE Roles SET name = $1, description = $2, max_upload_size = $3 WHERE role_id = $4",
+		role.Name, role.Description, role.MaxUploadSize, roleID,
 	)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
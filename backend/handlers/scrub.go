@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"backend/config"
+	"backend/storage"
+)
+
+// blobs checked per scrub run
+const scrubBatch = 100
+
+// ScrubBlobs re-reads blobs not verified within config.ScrubInterval and
+// compares them with the checksum and size recorded on upload. The result
+// is kept in Blobs.verified_at and verify_error, so files and versions of a
+// damaged blob show up as corrupted. Only a missing blob and data that fails
+// its checks count as damage, other storage errors leave the row to be
+// retried. Blobs are taken by Blobs.last_attempt_at, so the ones that could
+// not be read go after the rest instead of filling every batch.
+func ScrubBlobs() error {
+	if config.ScrubInterval <= 0 {
+		return nil
+	}
+
+	type scrubRow struct {
+		key    string
+		sha256 string
+		size   int64
+	}
+	var batch []scrubRow
+	rows, err := config.PostgresDB.Query(`
+		SELECT blob_key, sha256, size FROM Blobs
+		WHERE verified_at IS NULL OR verified_at < $1
+		ORDER BY last_attempt_at IS NOT NULL, last_attempt_at
+		LIMIT $2
+	`, time.Now().UTC().Add(-config.ScrubInterval), scrubBatch)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var row scrubRow
+		if err := rows.Scan(&row.key, &row.sha256, &row.size); err != nil {
+			rows.Close()
+			return err
+		}
+		batch = append(batch, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, row := range batch {
+		verifyError, err := scrubBlob(row.key, row.sha256, row.size)
+		if err != nil {
+			log.Printf("⚠️ Не удалось проверить блоб %s, повтор позже: %v", row.key, err)
+			failed++
+			_, err = config.PostgresDB.Exec(`
+				UPDATE Blobs SET last_attempt_at = $1 WHERE blob_key = $2
+			`, time.Now().UTC(), row.key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if verifyError != "" {
+			log.Printf("❌ Блоб %s поврежден: %s", row.key, verifyError)
+		}
+
+		_, err = config.PostgresDB.Exec(`
+			UPDATE Blobs SET verified_at = $1, last_attempt_at = $1, verify_error = $2 WHERE blob_key = $3
+		`, time.Now().UTC(), sql.NullString{String: verifyError, Valid: verifyError != ""}, row.key)
+		if err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d blobs could not be read", failed)
+	}
+	return nil
+}
+
+// scrubBlob reads a blob and describes what is wrong with it, or returns
+// an error if the storage could not be read
+func scrubBlob(key, expectedSHA256 string, expectedSize int64) (string, error) {
+	rc, err := config.Blobs.Get(context.Background(), key)
+	if err == storage.ErrNotFound {
+		return "blob not found", nil
+	} else if errors.Is(err, storage.ErrCorrupt) {
+		return err.Error(), nil
+	} else if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, rc)
+	if errors.Is(err, storage.ErrCorrupt) {
+		return err.Error(), nil
+	} else if err != nil {
+		return "", err
+	}
+	if size != expectedSize {
+		return fmt.Sprintf("size mismatch: expected %d bytes, read %d", expectedSize, size), nil
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != expectedSHA256 {
+		return "checksum mismatch", nil
+	}
+	return "", nil
+}
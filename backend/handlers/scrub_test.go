@@ -0,0 +1,62 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"backend/config"
+	"backend/handlers"
+	"backend/storage"
+)
+
+// unreachableBlobs fails to read the blobs with a key prefix like a
+// storage outage would
+type unreachableBlobs struct {
+	storage.BlobStore
+	prefix string
+}
+
+func (u unreachableBlobs) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if strings.HasPrefix(key, u.prefix) {
+		return nil, errors.New("connection timed out")
+	}
+	return u.BlobStore.Get(ctx, key)
+}
+
+func TestScrubRotatesUnreadableBlobs(t *testing.T) {
+	// more unreadable blobs than fit in one scrub batch
+	const unreadable = 101
+	for i := 0; i < unreadable; i++ {
+		_, err := config.PostgresDB.Exec(`
+			INSERT INTO Blobs (blob_key, sha256, size, stored_size) VALUES ($1, $2, 1, 1)
+		`, fmt.Sprintf("offline-%03d", i), fmt.Sprintf("%064d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	blobs := config.Blobs
+	config.Blobs = unreachableBlobs{blobs, "offline-"}
+	defer func() { config.Blobs = blobs }()
+
+	var total int
+	config.PostgresDB.QueryRow("SELECT COUNT(*) FROM Blobs").Scan(&total)
+	for run := 0; run <= total/100; run++ {
+		if err := handlers.ScrubBlobs(); err == nil {
+			t.Fatal("ScrubBlobs succeeded although blobs could not be read")
+		}
+	}
+
+	var waiting, damaged int
+	config.PostgresDB.QueryRow("SELECT COUNT(*) FROM Blobs WHERE blob_key LIKE 'offline-%' AND last_attempt_at IS NULL").Scan(&waiting)
+	config.PostgresDB.QueryRow("SELECT COUNT(*) FROM Blobs WHERE blob_key LIKE 'offline-%' AND verify_error IS NOT NULL").Scan(&damaged)
+	if waiting != 0 {
+		t.Errorf("%d unreadable blobs were never tried", waiting)
+	}
+	if damaged != 0 {
+		t.Errorf("%d unreadable blobs were marked damaged", damaged)
+	}
+}
@@ -0,0 +1,598 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+)
+
+// Share links open a file or folder to anyone with the /s/{token} URL. They
+// are created by users with reshare access and stop working when revoked,
+// expired, out of downloads or when their creator loses reshare access. A
+// password protected link takes the password in the X-Share-Password header
+// or a POSTed password form field, never in the URL where it would end up in
+// logs and Referer headers.
+
+const linkColumns = `link_id, token, file_id, folder_id, created_by, password IS NOT NULL,
+	mode, expires_at, max_downloads, download_count, revoked_at, create_date`
+
+func scanLink(row interface{ Scan(...interface{}) error }, extra ...interface{}) (models.ShareLink, error) {
+	var l models.ShareLink
+	err := row.Scan(append([]interface{}{
+		&l.LinkID, &l.Token, &l.FileID, &l.FolderID, &l.CreatedBy, &l.HasPassword,
+		&l.Mode, &l.ExpiresAt, &l.MaxDownloads, &l.DownloadCount, &l.RevokedAt, &l.CreateDate,
+	}, extra...)...)
+	return l, err
+}
+
+/*
+password: string, empty for none
+mode: "view" | "download", default "download"
+expires_at: RFC 3339 time | null, null never expires
+max_downloads: int | null, null is unlimited
+*/
+func CreateFileLink(w http.ResponseWriter, r *http.Request) {
+	auth, ok := authorizeFile(w, r, accessReshare)
+	if !ok {
+		return
+	}
+	createLink(w, r, "file_id", auth.FileID)
+}
+
+/*
+password: string, empty for none
+mode: "view" | "download", default "download"
+expires_at: RFC 3339 time | null, null never expires
+max_downloads: int | null, null is unlimited
+*/
+func CreateFolderLink(w http.ResponseWriter, r *http.Request) {
+	auth, ok := authorizeFolder(w, r, accessReshare)
+	if !ok {
+		return
+	}
+	createLink(w, r, "folder_id", auth.FolderID)
+}
+
+func GetFileLinks(w http.ResponseWriter, r *http.Request) {
+	auth, ok := authorizeFile(w, r, accessReshare)
+	if !ok {
+		return
+	}
+	writeLinks(w, "file_id", auth.FileID)
+}
+
+func GetFolderLinks(w http.ResponseWriter, r *http.Request) {
+	auth, ok := authorizeFolder(w, r, accessReshare)
+	if !ok {
+		return
+	}
+	writeLinks(w, "folder_id", auth.FolderID)
+}
+
+// RevokeLink stops a link from working, it is still listed with revoked_at
+func RevokeLink(w http.ResponseWriter, r *http.Request) {
+	link, err := scanLink(config.PostgresDB.QueryRow(`SELECT `+linkColumns+` FROM ShareLinks WHERE link_id = $1`, mux.Vars(r)["link_id"]))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !authorizeLink(w, r, link) {
+		return
+	}
+
+	_, err = config.PostgresDB.Exec(`
+		UPDATE ShareLinks SET revoked_at = COALESCE(revoked_at, $1) WHERE link_id = $2
+	`, time.Now().UTC(), link.LinkID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Link revoked"})
+}
+
+// authorizeLink checks that the caller has reshare access to the linked
+// file or folder
+func authorizeLink(w http.ResponseWriter, r *http.Request, link models.ShareLink) bool {
+	if link.FileID != nil {
+		_, ok := authorizeFileID(w, r, *link.FileID, accessReshare)
+		return ok
+	}
+	_, ok := authorizeFolderID(w, r, *link.FolderID, accessReshare)
+	return ok
+}
+
+// createLink adds a link to the file or folder
+func createLink(w http.ResponseWriter, r *http.Request, column string, id int) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password     string     `json:"password"`
+		Mode         string     `json:"mode"`
+		ExpiresAt    *time.Time `json:"expires_at"`
+		MaxDownloads *int       `json:"max_downloads"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = "download"
+	}
+	if req.Mode != "view" && req.Mode != "download" {
+		http.Error(w, "mode must be view or download", http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+			return
+		}
+		expiresAt := req.ExpiresAt.UTC()
+		req.ExpiresAt = &expiresAt
+	}
+	if req.MaxDownloads != nil && *req.MaxDownloads <= 0 {
+		http.Error(w, "max_downloads must be positive", http.StatusBadRequest)
+		return
+	}
+
+	var password *string
+	if req.Password != "" {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			return
+		}
+		hash := string(hashed)
+		password = &hash
+	}
+
+	token, err := newToken()
+	if err != nil {
+		http.Error(w, "Failed to create link", http.StatusInternalServerError)
+		return
+	}
+
+	link, err := scanLink(config.PostgresDB.QueryRow(`
+		INSERT INTO ShareLinks (token, `+column+`, created_by, password, mode, expires_at, max_downloads, create_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING `+linkColumns,
+		token, id, userID, password, req.Mode,
+		req.ExpiresAt, req.MaxDownloads, time.Now().UTC(),
+	))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+// newToken returns a random token for a public URL
+func newToken() (string, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(tokenBytes), nil
+}
+
+// writeLinks answers with the links to the file or folder, newest first
+func writeLinks(w http.ResponseWriter, column string, id int) {
+	rows, err := config.PostgresDB.Query(`
+		SELECT `+linkColumns+` FROM ShareLinks WHERE `+column+` = $1 ORDER BY link_id DESC
+	`, id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	links := []models.ShareLink{}
+	for rows.Next() {
+		link, err := scanLink(rows)
+		if err != nil {
+			http.Error(w, "Row scan error", http.StatusInternalServerError)
+			return
+		}
+		links = append(links, link)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+// OpenLink shows the linked file, or the folders and files directly in the
+// linked folder
+func OpenLink(w http.ResponseWriter, r *http.Request) {
+	link, ok := openLink(w, r)
+	if !ok {
+		return
+	}
+
+	public := models.PublicLink{Mode: link.Mode, ExpiresAt: link.ExpiresAt}
+	if link.MaxDownloads != nil {
+		left := *link.MaxDownloads - link.DownloadCount
+		public.DownloadsLeft = &left
+	}
+
+	var err error
+	if link.FileID != nil {
+		var files []models.FileMetadata
+		files, err = listFiles("f.file_id = $1", *link.FileID)
+		if err == nil && len(files) == 0 {
+			err = sql.ErrNoRows
+		}
+		if err == nil {
+			public.Type = "file"
+			public.Name = files[0].Name
+			public.Size = files[0].Size
+		}
+	} else {
+		public.Type = "folder"
+		err = config.PostgresDB.QueryRow("SELECT name FROM Folders WHERE folder_id = $1", *link.FolderID).Scan(&public.Name)
+		if err == nil {
+			public.Folders, public.Files, err = publicChildren(link, *link.FolderID)
+		}
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(public)
+}
+
+// OpenLinkFolder lists a folder below the linked folder
+func OpenLinkFolder(w http.ResponseWriter, r *http.Request) {
+	link, ok := openLink(w, r)
+	if !ok {
+		return
+	}
+	folderID, err := strconv.Atoi(mux.Vars(r)["folder_id"])
+	if err != nil {
+		http.Error(w, "Invalid folder ID", http.StatusBadRequest)
+		return
+	}
+	if link.FolderID == nil {
+		http.Error(w, "Link is to a file", http.StatusBadRequest)
+		return
+	}
+	linked, err := linkedFolder(link, &folderID)
+	if err == nil && linked {
+		// a nearer grant may have taken the folder from the link's creator
+		var level int
+		level, err = creatorFolderLevel(link, folderID)
+		linked = level >= accessReshare
+	}
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if !linked {
+		http.Error(w, "Folder not found", http.StatusNotFound)
+		return
+	}
+
+	folders, files, err := publicChildren(link, folderID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"folders": folders,
+		"files":   files,
+	})
+}
+
+// DownloadLink answers with the content of the linked file, see
+// countDownload for what counts as a download
+func DownloadLink(w http.ResponseWriter, r *http.Request) {
+	link, ok := openLink(w, r)
+	if !ok {
+		return
+	}
+	if link.FileID == nil {
+		http.Error(w, "Link is to a folder", http.StatusBadRequest)
+		return
+	}
+	if !countDownload(w, r, link, *link.FileID) {
+		return
+	}
+	serveFile(w, r, *link.FileID)
+}
+
+// DownloadLinkFile answers with the content of a file below the linked
+// folder, see countDownload for what counts as a download
+func DownloadLinkFile(w http.ResponseWriter, r *http.Request) {
+	link, ok := openLink(w, r)
+	if !ok {
+		return
+	}
+	if link.FolderID == nil {
+		http.Error(w, "Link is to a file", http.StatusBadRequest)
+		return
+	}
+	fileID, err := strconv.Atoi(mux.Vars(r)["file_id"])
+	if err != nil {
+		http.Error(w, "Invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	var folderID *int
+	err = config.PostgresDB.QueryRow("SELECT folder_id FROM Files WHERE file_id = $1 AND deleted_at IS NULL", fileID).Scan(&folderID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	linked, err := linkedFolder(link, folderID)
+	if err == nil && linked {
+		// a nearer grant may have taken the file from the link's creator
+		var auth fileAuth
+		auth, err = fileAccess(config.PostgresDB, fileID, link.CreatedBy)
+		linked = auth.Level >= accessReshare
+	}
+	if err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	} else if !linked {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if !countDownload(w, r, link, fileID) {
+		return
+	}
+	serveFile(w, r, fileID)
+}
+
+// openLink loads the link in the {token} route variable and checks that it
+// still works and that the request carries its password
+func openLink(w http.ResponseWriter, r *http.Request) (models.ShareLink, bool) {
+	var password sql.NullString
+	link, err := scanLink(config.PostgresDB.QueryRow(`
+		SELECT `+linkColumns+`, password FROM ShareLinks WHERE token = $1
+	`, mux.Vars(r)["token"]), &password)
+	if err == nil && link.RevokedAt == nil {
+		err = checkLinkCreator(link)
+	} else if err == nil {
+		err = sql.ErrNoRows
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return link, false
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return link, false
+	}
+	if link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now()) {
+		http.Error(w, "Link expired", http.StatusGone)
+		return link, false
+	}
+
+	if password.Valid {
+		given := r.Header.Get("X-Share-Password")
+		if given == "" && r.Method == http.MethodPost {
+			given = r.PostFormValue("password")
+		}
+		if given == "" {
+			http.Error(w, "Password required", http.StatusUnauthorized)
+			return link, false
+		}
+		if !models.CheckPasswordHash(given, password.String) {
+			http.Error(w, "Invalid password", http.StatusUnauthorized)
+			return link, false
+		}
+	}
+	return link, true
+}
+
+// checkLinkCreator returns sql.ErrNoRows if the linked item is gone or its
+// creator no longer has reshare access to it
+func checkLinkCreator(link models.ShareLink) error {
+	level := accessNone
+	if link.FileID != nil {
+		auth, err := fileAccess(config.PostgresDB, *link.FileID, link.CreatedBy)
+		if err != nil {
+			return err
+		}
+		level = auth.Level
+	} else {
+		var err error
+		if level, err = creatorFolderLevel(link, *link.FolderID); err != nil {
+			return err
+		}
+	}
+	if level < accessReshare {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// creatorFolderLevel returns the access level of the link's creator on a
+// folder, sql.ErrNoRows if there is no such folder
+func creatorFolderLevel(link models.ShareLink, folderID int) (int, error) {
+	folder, err := scanFolder(config.PostgresDB.QueryRow(`SELECT `+folderColumns+` FROM Folders WHERE folder_id = $1`, folderID))
+	if err != nil {
+		return accessNone, err
+	}
+	return folderAccess(config.PostgresDB, folder, link.CreatedBy)
+}
+
+// linkedFolder reports whether the folder is the linked folder or below it
+func linkedFolder(link models.ShareLink, folderID *int) (bool, error) {
+	for id := folderID; id != nil; {
+		if *id == *link.FolderID {
+			return true, nil
+		}
+		var parentID *int
+		err := config.PostgresDB.QueryRow("SELECT parent_id FROM Folders WHERE folder_id = $1", *id).Scan(&parentID)
+		if err != nil && err != sql.ErrNoRows {
+			return false, err
+		}
+		id = parentID
+	}
+	return false, nil
+}
+
+// linkDownloadSession is how long a download of a link may be resumed
+// without counting again
+const linkDownloadSession = 12 * time.Hour
+
+// downloadClaims is the resume token of a counted link download
+type downloadClaims struct {
+	LinkID int `json:"link_id"`
+	FileID int `json:"file_id"`
+	jwt.RegisteredClaims
+}
+
+// countDownload answers 403 for view only links and 410 once the download
+// limit is reached. Every request for the content counts, whatever its
+// Range, unless it carries the X-Download-Token (or download_token query
+// parameter) answered with a counted download of the same file, so one
+// download may be resumed or seeked within linkDownloadSession.
+func countDownload(w http.ResponseWriter, r *http.Request, link models.ShareLink, fileID int) bool {
+	if link.Mode != "download" {
+		http.Error(w, "Link is view only", http.StatusForbidden)
+		return false
+	}
+	token := r.Header.Get("X-Download-Token")
+	if token == "" {
+		token = r.URL.Query().Get("download_token")
+	}
+	if token != "" && resumesDownload(token, link, fileID) {
+		return true
+	}
+	if link.MaxDownloads != nil && link.DownloadCount >= *link.MaxDownloads {
+		http.Error(w, "Download limit reached", http.StatusGone)
+		return false
+	}
+	if r.Method == http.MethodHead {
+		return true
+	}
+
+	// concurrent downloads may have used up the limit since the link was read
+	res, err := config.PostgresDB.Exec(`
+		UPDATE ShareLinks SET download_count = download_count + 1
+		WHERE link_id = $1 AND (max_downloads IS NULL OR download_count < max_downloads)
+	`, link.LinkID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Download limit reached", http.StatusGone)
+		return false
+	}
+
+	claims := &downloadClaims{
+		LinkID: link.LinkID,
+		FileID: fileID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "download",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(linkDownloadSession)),
+		},
+	}
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+	if err != nil {
+		log.Printf("⚠️ Не удалось выдать токен докачки для ссылки %d: %v", link.LinkID, err)
+	} else {
+		w.Header().Set("X-Download-Token", token)
+	}
+	return true
+}
+
+// resumesDownload reports whether token is a valid resume token for the
+// file of the link
+func resumesDownload(token string, link models.ShareLink, fileID int) bool {
+	claims := &downloadClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	return err == nil && parsed.Valid && claims.Subject == "download" &&
+		claims.LinkID == link.LinkID && claims.FileID == fileID
+}
+
+// publicChildren returns the folders and files directly in a folder that
+// the link's creator may still share, a nearer grant may have taken some
+// of them away
+func publicChildren(link models.ShareLink, folderID int) ([]models.PublicFolder, []models.PublicFile, error) {
+	rows, err := config.PostgresDB.Query(`SELECT `+folderColumns+` FROM Folders WHERE parent_id = $1 ORDER BY name`, folderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var children []models.Folder
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		children = append(children, folder)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	folders := []models.PublicFolder{}
+	for _, folder := range children {
+		level, err := folderAccess(config.PostgresDB, folder, link.CreatedBy)
+		if err != nil {
+			return nil, nil, err
+		}
+		if level >= accessReshare {
+			folders = append(folders, models.PublicFolder{FolderID: folder.FolderID, Name: folder.Name})
+		}
+	}
+
+	metadata, err := listFiles("f.folder_id = $1 AND f.deleted_at IS NULL ORDER BY f.name", folderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	files := []models.PublicFile{}
+	for _, file := range metadata {
+		auth, err := fileAccess(config.PostgresDB, file.FileID, link.CreatedBy)
+		if err != nil {
+			return nil, nil, err
+		}
+		if auth.Level < accessReshare {
+			continue
+		}
+		files = append(files, models.PublicFile{
+			FileID:   file.FileID,
+			Name:     file.Name,
+			Type:     file.Type,
+			Size:     file.Size,
+			EditDate: file.EditDate,
+		})
+	}
+	return folders, files, nil
+}
@@ -0,0 +1,99 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestShareLinkPassword(t *testing.T) {
+	alice := newUser(t)
+	fileID := uploadFile(t, alice, "report.txt", "quarterly numbers")
+
+	var link struct {
+		Token string `json:"token"`
+	}
+	path := fmt.Sprintf("/api/files/%d/links", fileID)
+	decode(t, request(t, "POST", path, alice.token, strings.NewReader(`{"password":"pw"}`), nil), http.StatusCreated, &link)
+
+	download := "/s/" + link.Token + "/download"
+	readBody(t, request(t, "GET", download, "", nil, nil), http.StatusUnauthorized)
+	readBody(t, request(t, "GET", download+"?password=pw", "", nil, nil), http.StatusUnauthorized)
+	readBody(t, request(t, "GET", download, "", nil, http.Header{"X-Share-Password": {"wrong"}}), http.StatusUnauthorized)
+
+	resp := request(t, "GET", download, "", nil, http.Header{"X-Share-Password": {"pw"}})
+	if body := readBody(t, resp, http.StatusOK); body != "quarterly numbers" {
+		t.Errorf("link body = %q", body)
+	}
+}
+
+func TestFolderLinkHidesOverriddenItems(t *testing.T) {
+	alice, bob := newUser(t), newUser(t)
+	folderID := createFolder(t, alice, 0, "shared")
+	hiddenFolderID := createFolder(t, alice, folderID, "hidden")
+	visibleID := uploadFileInto(t, alice, folderID, "visible.txt", "for everyone")
+	hiddenID := uploadFileInto(t, alice, folderID, "hidden.txt", "not for bob")
+	deepID := uploadFileInto(t, alice, hiddenFolderID, "deep.txt", "not for bob either")
+
+	readBody(t, share(t, alice, fmt.Sprintf("folders/%d", folderID), bob.id, "reshare"), http.StatusCreated)
+	readBody(t, share(t, alice, fmt.Sprintf("files/%d", hiddenID), bob.id, ""), http.StatusCreated)
+	readBody(t, share(t, alice, fmt.Sprintf("folders/%d", hiddenFolderID), bob.id, "view"), http.StatusCreated)
+
+	var link struct {
+		Token string `json:"token"`
+	}
+	path := fmt.Sprintf("/api/folders/%d/links", folderID)
+	decode(t, request(t, "POST", path, bob.token, strings.NewReader(`{}`), nil), http.StatusCreated, &link)
+
+	var opened struct {
+		Folders []struct {
+			FolderID int `json:"folder_id"`
+		} `json:"folders"`
+		Files []struct {
+			FileID int `json:"file_id"`
+		} `json:"files"`
+	}
+	decode(t, request(t, "GET", "/s/"+link.Token, "", nil, nil), http.StatusOK, &opened)
+	if len(opened.Folders) != 0 || len(opened.Files) != 1 || opened.Files[0].FileID != visibleID {
+		t.Errorf("link lists %+v, want only file %d", opened, visibleID)
+	}
+
+	base := "/s/" + link.Token
+	readBody(t, request(t, "GET", fmt.Sprintf("%s/files/%d", base, visibleID), "", nil, nil), http.StatusOK)
+	readBody(t, request(t, "GET", fmt.Sprintf("%s/files/%d", base, hiddenID), "", nil, nil), http.StatusNotFound)
+	readBody(t, request(t, "GET", fmt.Sprintf("%s/files/%d", base, deepID), "", nil, nil), http.StatusNotFound)
+	readBody(t, request(t, "GET", fmt.Sprintf("%s/folders/%d", base, hiddenFolderID), "", nil, nil), http.StatusNotFound)
+}
+
+func TestShareLinkDownloadLimit(t *testing.T) {
+	alice := newUser(t)
+	fileID := uploadFile(t, alice, "movie.txt", "0123456789")
+
+	var link struct {
+		Token string `json:"token"`
+	}
+	path := fmt.Sprintf("/api/files/%d/links", fileID)
+	decode(t, request(t, "POST", path, alice.token, strings.NewReader(`{"max_downloads":2}`), nil), http.StatusCreated, &link)
+	download := "/s/" + link.Token + "/download"
+
+	// a probe of the first byte is a download, its token resumes it
+	resp := request(t, "GET", download, "", nil, http.Header{"Range": {"bytes=0-0"}})
+	token := resp.Header.Get("X-Download-Token")
+	readBody(t, resp, http.StatusPartialContent)
+	if token == "" {
+		t.Fatal("no X-Download-Token")
+	}
+	resume := http.Header{"Range": {"bytes=1-"}, "X-Download-Token": {token}}
+	if body := readBody(t, request(t, "GET", download, "", nil, resume), http.StatusPartialContent); body != "123456789" {
+		t.Errorf("resumed body = %q", body)
+	}
+
+	// a range without a token is a download of its own
+	readBody(t, request(t, "GET", download, "", nil, http.Header{"Range": {"bytes=1-"}}), http.StatusPartialContent)
+	readBody(t, request(t, "GET", download, "", nil, http.Header{"Range": {"bytes=5-"}}), http.StatusGone)
+	readBody(t, request(t, "GET", download, "", nil, http.Header{"X-Download-Token": {alice.token}}), http.StatusGone)
+
+	// started downloads can still be finished
+	readBody(t, request(t, "GET", download+"?download_token="+token, "", nil, nil), http.StatusOK)
+}
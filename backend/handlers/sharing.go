@@ -4,258 +4,558 @@ import (
 	"backend/config"
 	"backend/middleware"
 	"backend/models"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// Shares of a file or folder may be viewed and changed by its owner, admins
+// and users with reshare access. Every change answers with the resulting
+// shares of the item, like GetFilePermissions.
+
+// aclItem is a file or folder whose shares are managed
+type aclItem struct {
+	table  string // "File" or "Folder", prefix of the _Users and _Groups tables
+	column string
+	id     int
+}
+
 /*
 user_id: int
-access_id: int
+access_id: int | null, null removes access inherited from folders
+expires_at: RFC 3339 time | null, null never expires
 */
 func ShareFileWithUser(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	fileID := vars["file_id"]
-
-	var req struct {
-		UserID   int `json:"user_id"`
-		AccessID int `json:"access_id"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	_, err := config.PostgresDB.Exec(
-		"INSERT INTO File_Users (file_id, user_id, access_id) VALUES ($1, $2, $3) ON CONFLICT (file_id, user_id) DO UPDATE SET access_id = $3",
-		fileID, req.UserID, req.AccessID,
-	)
-	if err != nil {
-		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+	auth, ok := authorizeFile(w, r, accessReshare)
+	if !ok {
 		return
 	}
-
-	w.WriteHeader(http.StatusCreated)
+	share(w, r, aclItem{"File", "file_id", auth.FileID}, "user_id", auth.Level)
 }
 
 /*
 group_id: int
-access_id: int
+access_id: int | null, null removes access inherited from folders
+expires_at: RFC 3339 time | null, null never expires
 */
 func ShareFileWithGroup(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	fileID := vars["file_id"]
-
-	var req struct {
-		GroupID  int `json:"group_id"`
-		AccessID int `json:"access_id"`
+	auth, ok := authorizeFile(w, r, accessReshare)
+	if !ok {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	share(w, r, aclItem{"File", "file_id", auth.FileID}, "group_id", auth.Level)
+}
+
+func GetFilePermissions(w http.ResponseWriter, r *http.Request) {
+	auth, ok := authorizeFile(w, r, accessReshare)
+	if !ok {
 		return
 	}
+	writeACL(w, http.StatusOK, aclItem{"File", "file_id", auth.FileID})
+}
 
-	_, err := config.PostgresDB.Exec(
-		"INSERT INTO File_Groups (file_id, group_id, access_id) VALUES ($1, $2, $3) ON CONFLICT (file_id, group_id) DO UPDATE SET access_id = $3",
-		fileID, req.GroupID, req.AccessID,
-	)
-	if err != nil {
-		http.Error(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+// GetSharedFiles lists the files the caller has access to through a share
+// of the file or of a folder above it
+func GetSharedFiles(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-}
+	search := r.URL.Query().Get("search")
 
-func GetFilePermissions(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	fileID := vars["file_id"]
+	// files with a grant to the caller or the caller's group, the effective
+	// access is checked below
+	query := `
+		WITH RECURSIVE shared_folders (folder_id) AS (
+			SELECT folder_id FROM Folders
+			WHERE folder_id IN (SELECT folder_id FROM Folder_Users WHERE user_id = $1)
+				OR folder_id IN (
+					SELECT fg.folder_id FROM Folder_Groups fg
+					JOIN Users u ON u.group_id = fg.group_id
+					WHERE u.user_id = $1
+				)
+			UNION
+			SELECT f.folder_id FROM Folders f
+			JOIN shared_folders s ON f.parent_id = s.folder_id
+		)
+		SELECT f.file_id, f.name, f.full_path, f.owner_id, f.version_id, f.create_date, f.edit_date
+		FROM Files f
+		WHERE f.deleted_at IS NULL AND (
+			f.file_id IN (SELECT file_id FROM File_Users WHERE user_id = $1)
+			OR f.file_id IN (
+				SELECT fg.file_id FROM File_Groups fg
+				JOIN Users u ON u.group_id = fg.group_id
+				WHERE u.user_id = $1
+			)
+			OR f.folder_id IN (SELECT folder_id FROM shared_folders)
+		)
+	`
+	args := []interface{}{userID}
+
+	// Add search filter
+	if search != "" {
+		query += " AND f.name ILIKE $2"
+		args = append(args, "%"+search+"%")
+	}
 
-	// get users with access
-	userRows, err := config.PostgresDB.Query("SELECT user_id, access_id FROM File_Users WHERE file_id = $1", fileID)
+	rows, err := config.PostgresDB.Query(query, args...)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	defer userRows.Close()
+	defer rows.Close()
 
-	var users []models.FilePermissionUser
-	for userRows.Next() {
-		var user models.FilePermissionUser
-		if err := userRows.Scan(&user.UserID, &user.AccessID); err != nil {
-			http.Error(w, "Error scanning users", http.StatusInternalServerError)
+	var candidates []models.SharedFile
+	for rows.Next() {
+		var file models.SharedFile
+		err := rows.Scan(&file.FileID, &file.Name, &file.FullPath, &file.OwnerID, &file.VersionID, &file.CreateDate, &file.EditDate)
+		if err != nil {
+			http.Error(w, "Error scanning shared files", http.StatusInternalServerError)
 			return
 		}
-		users = append(users, user)
+		candidates = append(candidates, file)
 	}
+	rows.Close()
 
-	// get groups with access
-	groupRows, err := config.PostgresDB.Query("SELECT group_id, access_id FROM File_Groups WHERE file_id = $1", fileID)
-	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+	var files []models.SharedFile
+	for _, file := range candidates {
+		access, groupIDs, err := userAccess(config.PostgresDB, file.FileID, userID)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if access.Level > accessNone {
+			file.Access, file.GroupIDs = access.Name, groupIDs
+			files = append(files, file)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+func RevokeUserAccess(w http.ResponseWriter, r *http.Request) {
+	auth, ok := authorizeFile(w, r, accessReshare)
+	if !ok {
 		return
 	}
-	defer groupRows.Close()
+	revoke(w, r, aclItem{"File", "file_id", auth.FileID}, "user_id", auth.Level)
+}
 
-	var groups []models.FilePermissionGroup
-	for groupRows.Next() {
-		var group models.FilePermissionGroup
-		if err := groupRows.Scan(&group.GroupID, &group.AccessID); err != nil {
-			http.Error(w, "Error scanning groups", http.StatusInternalServerError)
-			return
-		}
-		groups = append(groups, group)
+func RevokeGroupAccess(w http.ResponseWriter, r *http.Request) {
+	auth, ok := authorizeFile(w, r, accessReshare)
+	if !ok {
+		return
 	}
+	revoke(w, r, aclItem{"File", "file_id", auth.FileID}, "group_id", auth.Level)
+}
 
-	response := map[string]interface{}{
-		"users":  users,
-		"groups": groups,
+// GetEffectiveFilePermissions lists the grants on a file and on the folders
+// above it, nearest first, with the ones overridden by a nearer grant
+// flagged
+func GetEffectiveFilePermissions(w http.ResponseWriter, r *http.Request) {
+	auth, ok := authorizeFile(w, r, accessReshare)
+	if !ok {
+		return
+	}
+
+	grants, err := fileGrants(config.PostgresDB, auth.FileID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if grants == nil {
+		grants = []models.AccessGrant{}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(grants)
 }
 
-func GetSharedFiles(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+/*
+user_id: int
+access_id: int | null, null removes access inherited from parent folders
+expires_at: RFC 3339 time | null, null never expires
+*/
+func ShareFolderWithUser(w http.ResponseWriter, r *http.Request) {
+	folder, ok := authorizeFolder(w, r, accessReshare)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	share(w, r, aclItem{"Folder", "folder_id", folder.FolderID}, "user_id", folder.Level)
+}
 
-	search := r.URL.Query().Get("search")
+/*
+group_id: int
+access_id: int | null, null removes access inherited from parent folders
+expires_at: RFC 3339 time | null, null never expires
+*/
+func ShareFolderWithGroup(w http.ResponseWriter, r *http.Request) {
+	folder, ok := authorizeFolder(w, r, accessReshare)
+	if !ok {
+		return
+	}
+	share(w, r, aclItem{"Folder", "folder_id", folder.FolderID}, "group_id", folder.Level)
+}
 
-	// Base SQL
-	userFilesQuery := `
-		SELECT f.file_id, f.name, f.full_path, f.owner_id, fu.access_id, f.version_id, f.create_date, f.edit_date
-		FROM Files f
-		JOIN File_Users fu ON f.file_id = fu.file_id
-		WHERE fu.user_id = $1
-	`
-	groupFilesQuery := `
-		SELECT f.file_id, f.name, f.full_path, f.owner_id, fg.group_id, fg.access_id, f.version_id, f.create_date, f.edit_date
-		FROM Files f
-		JOIN File_Groups fg ON f.file_id = fg.file_id
-		JOIN Users u ON u.group_id = fg.group_id
-		WHERE u.user_id = $1
-	`
+// GetFolderPermissions lists the grants on the folder itself
+func GetFolderPermissions(w http.ResponseWriter, r *http.Request) {
+	folder, ok := authorizeFolder(w, r, accessReshare)
+	if !ok {
+		return
+	}
+	writeACL(w, http.StatusOK, aclItem{"Folder", "folder_id", folder.FolderID})
+}
 
-	var userArgs = []interface{}{userID}
-	var groupArgs = []interface{}{userID}
+func RevokeFolderUserAccess(w http.ResponseWriter, r *http.Request) {
+	folder, ok := authorizeFolder(w, r, accessReshare)
+	if !ok {
+		return
+	}
+	revoke(w, r, aclItem{"Folder", "folder_id", folder.FolderID}, "user_id", folder.Level)
+}
 
-	// Add search filter
-	if search != "" {
-		userFilesQuery += " AND f.name ILIKE $2"
-		groupFilesQuery += " AND f.name ILIKE $2"
-		userArgs = append(userArgs, "%"+search+"%")
-		groupArgs = append(groupArgs, "%"+search+"%")
+func RevokeFolderGroupAccess(w http.ResponseWriter, r *http.Request) {
+	folder, ok := authorizeFolder(w, r, accessReshare)
+	if !ok {
+		return
 	}
+	revoke(w, r, aclItem{"Folder", "folder_id", folder.FolderID}, "group_id", folder.Level)
+}
 
-	filesMap := make(map[int]*models.SharedFile)
+// GetEffectiveFolderPermissions lists the grants on a folder and its
+// parents like GetEffectiveFilePermissions
+func GetEffectiveFolderPermissions(w http.ResponseWriter, r *http.Request) {
+	folder, ok := authorizeFolder(w, r, accessReshare)
+	if !ok {
+		return
+	}
 
-	// Files shared by user
-	userRows, err := config.PostgresDB.Query(userFilesQuery, userArgs...)
+	grants, err := folderGrants(config.PostgresDB, &folder.FolderID)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	defer userRows.Close()
+	grants = markOverridden(grants)
+	if grants == nil {
+		grants = []models.AccessGrant{}
+	}
 
-	for userRows.Next() {
-		var fileID, ownerID, accessID, versionID int
-		var name, fullPath, createDate, editDate string
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grants)
+}
 
-		err := userRows.Scan(&fileID, &name, &fullPath, &ownerID, &accessID, &versionID, &createDate, &editDate)
-		if err != nil {
-			http.Error(w, "Error scanning user files", http.StatusInternalServerError)
+// principalTable returns the table of the item's grants to users or groups
+func (item aclItem) principalTable(principal string) string {
+	if principal == "group_id" {
+		return item.table + "_Groups"
+	}
+	return item.table + "_Users"
+}
+
+// share grants the user or group in the request body access to the item,
+// up to the caller's level
+func share(w http.ResponseWriter, r *http.Request, item aclItem, principal string, level int) {
+	var req struct {
+		UserID    int        `json:"user_id"`
+		GroupID   int        `json:"group_id"`
+		AccessID  *int       `json:"access_id"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	id := req.UserID
+	if principal == "group_id" {
+		id = req.GroupID
+	}
+	if !validGrant(w, principal, id, req.AccessID, level) {
+		return
+	}
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
 			return
 		}
+		expiresAt := req.ExpiresAt.UTC()
+		req.ExpiresAt = &expiresAt
+	}
 
-		filesMap[fileID] = &models.SharedFile{
-			FileID:     fileID,
-			Name:       name,
-			FullPath:   fullPath,
-			OwnerID:    ownerID,
-			GroupIDs:   nil,
-			AccessID:   accessID,
-			VersionID:  versionID,
-			CreateDate: createDate,
-			EditDate:   editDate,
+	err := withTx(func(tx *sql.Tx) error {
+		if err := grantWithin(tx, item, principal, id, level, false); err != nil {
+			return err
 		}
+		_, err := tx.Exec(
+			"INSERT INTO "+item.principalTable(principal)+" ("+item.column+", "+principal+", access_id, expires_at) VALUES ($1, $2, $3, $4) "+
+				"ON CONFLICT ("+item.column+", "+principal+") DO UPDATE SET access_id = $3, expires_at = $4",
+			item.id, id, req.AccessID, req.ExpiresAt,
+		)
+		return err
+	})
+	if err == errGrantAbove {
+		http.Error(w, "Cannot change access above your own", http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
 	}
 
-	// Files shared by group
-	groupRows, err := config.PostgresDB.Query(groupFilesQuery, groupArgs...)
+	writeACL(w, http.StatusCreated, item)
+}
+
+// revoke removes the grant to the user or group in the route variable, if
+// it is not above the caller's level
+func revoke(w http.ResponseWriter, r *http.Request, item aclItem, principal string, level int) {
+	id, err := strconv.Atoi(mux.Vars(r)[principal])
 	if err != nil {
+		http.Error(w, "Invalid "+principal, http.StatusBadRequest)
+		return
+	}
+
+	err = withTx(func(tx *sql.Tx) error {
+		if err := grantWithin(tx, item, principal, id, level, true); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			"DELETE FROM "+item.principalTable(principal)+" WHERE "+item.column+" = $1 AND "+principal+" = $2",
+			item.id, id,
+		)
+		return err
+	})
+	if err == errGrantAbove {
+		http.Error(w, "Cannot change access above your own", http.StatusForbidden)
+		return
+	} else if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	defer groupRows.Close()
 
-	for groupRows.Next() {
-		var fileID, ownerID, groupID, accessID, versionID int
-		var name, fullPath, createDate, editDate string
+	writeACL(w, http.StatusOK, item)
+}
 
-		err := groupRows.Scan(&fileID, &name, &fullPath, &ownerID, &groupID, &accessID, &versionID, &createDate, &editDate)
-		if err != nil {
-			http.Error(w, "Error scanning group files", http.StatusInternalServerError)
-			return
-		}
+var errGrantAbove = errors.New("grant is above the caller's access")
+
+// grantWithin locks the grant to the user or group on the item and returns
+// errGrantAbove if the access the principal has now, on the item or from a
+// folder above it, is above level, or is a null override and level is below
+// full control. A revoke also checks the access the principal gets back from
+// the folders once the item's own grant is gone.
+func grantWithin(tx *sql.Tx, item aclItem, principal string, id int, level int, revoking bool) error {
+	var accessID sql.NullInt64
+	err := tx.QueryRow(
+		"SELECT access_id FROM "+item.principalTable(principal)+" WHERE "+item.column+" = $1 AND "+principal+" = $2 FOR UPDATE",
+		item.id, id,
+	).Scan(&accessID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
 
-		if file, exists := filesMap[fileID]; exists {
-			file.GroupIDs = append(file.GroupIDs, groupID)
-			if accessID > file.AccessID {
-				file.AccessID = accessID
-			}
-		} else {
-			filesMap[fileID] = &models.SharedFile{
-				FileID:     fileID,
-				Name:       name,
-				FullPath:   fullPath,
-				OwnerID:    ownerID,
-				GroupIDs:   []int{groupID},
-				AccessID:   accessID,
-				VersionID:  versionID,
-				CreateDate: createDate,
-				EditDate:   editDate,
-			}
+	var grants []models.AccessGrant
+	if item.table == "File" {
+		grants, err = fileGrants(tx, item.id)
+	} else {
+		grants, err = folderGrants(tx, &item.id)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, grant := range grants {
+		mine := grant.UserID != nil && principal == "user_id" && *grant.UserID == id
+		mine = mine || (grant.GroupID != nil && principal == "group_id" && *grant.GroupID == id)
+		if !mine {
+			continue
+		}
+		if grant.AccessID == nil && level < accessFullControl {
+			return errGrantAbove
+		}
+		if grant.Level > level {
+			return errGrantAbove
+		}
+		own := grant.Source == "file" || (grant.FolderID != nil && *grant.FolderID == item.id && item.table == "Folder")
+		if !revoking || !own {
+			break
 		}
 	}
+	return nil
+}
 
-	var files []models.SharedFile
-	for _, file := range filesMap {
-		files = append(files, *file)
+// validGrant answers 400 unless the user or group and the access exist, and
+// 403 if the access is above maxLevel
+func validGrant(w http.ResponseWriter, principal string, id int, accessID *int, maxLevel int) bool {
+	query := "SELECT EXISTS (SELECT 1 FROM Users WHERE user_id = $1)"
+	message := "User not found"
+	if principal == "group_id" {
+		query = "SELECT EXISTS (SELECT 1 FROM Groups WHERE group_id = $1)"
+		message = "Group not found"
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(files)
-}
+	var exists bool
+	err := config.PostgresDB.QueryRow(query, id).Scan(&exists)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	if !exists {
+		http.Error(w, message, http.StatusBadRequest)
+		return false
+	}
+	if accessID == nil {
+		return true
+	}
 
-func RevokeUserAccess(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	fileID := vars["file_id"]
-	userID := vars["user_id"]
+	var level int
+	err = config.PostgresDB.QueryRow("SELECT level FROM Access WHERE access_id = $1", *accessID).Scan(&level)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid access_id", http.StatusBadRequest)
+		return false
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	if level > maxLevel {
+		http.Error(w, "Cannot grant more access than you have", http.StatusForbidden)
+		return false
+	}
+	return true
+}
 
-	_, err := config.PostgresDB.Exec("DELETE FROM File_Users WHERE file_id = $1 AND user_id = $2", fileID, userID)
+// writeACL answers with the grants on the item itself
+func writeACL(w http.ResponseWriter, status int, item aclItem) {
+	grants, err := loadGrants(config.PostgresDB, item.table, item.column, item.id)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-}
+	users := []models.FilePermissionUser{}
+	groups := []models.FilePermissionGroup{}
+	for _, grant := range grants {
+		if grant.UserID != nil {
+			users = append(users, models.FilePermissionUser{UserID: *grant.UserID, AccessID: grant.AccessID, Access: grant.Access, ExpiresAt: grant.ExpiresAt})
+		} else {
+			groups = append(groups, models.FilePermissionGroup{GroupID: *grant.GroupID, AccessID: grant.AccessID, Access: grant.Access, ExpiresAt: grant.ExpiresAt})
+		}
+	}
 
-func RevokeGroupAccess(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	fileID := vars["file_id"]
-	groupID := vars["group_id"]
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users":  users,
+		"groups": groups,
+	})
+}
 
-	_, err := config.PostgresDB.Exec("DELETE FROM File_Groups WHERE file_id = $1 AND group_id = $2", fileID, groupID)
+// GetAccessLevels lists the access levels that can be granted, lowest first
+func GetAccessLevels(w http.ResponseWriter, r *http.Request) {
+	rows, err := config.PostgresDB.Query("SELECT access_id, name, level FROM Access ORDER BY level")
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
+	defer rows.Close()
+
+	levels := []models.Access{}
+	for rows.Next() {
+		var access models.Access
+		if err := rows.Scan(&access.AccessID, &access.Name, &access.Level); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		levels = append(levels, access)
+	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(levels)
+}
+
+// PurgeExpiredShares deletes grants past their expires_at and notifies the
+// owners of the shared files and folders
+func PurgeExpiredShares() error {
+	for _, item := range []aclItem{{"File", "file_id", 0}, {"Folder", "folder_id", 0}} {
+		for _, principal := range []string{"user_id", "group_id"} {
+			if err := purgeExpiredGrants(item, principal); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func purgeExpiredGrants(item aclItem, principal string) error {
+	table := item.principalTable(principal)
+	principalName := "SELECT login FROM Users p WHERE p.user_id = g.user_id"
+	kind := "user"
+	if principal == "group_id" {
+		principalName = "SELECT name FROM Groups p WHERE p.group_id = g.group_id"
+		kind = "group"
+	}
+
+	now := time.Now().UTC()
+	rows, err := config.PostgresDB.Query(
+		"SELECT g."+item.column+", g."+principal+", i.owner_id, i.name, ("+principalName+") "+
+			"FROM "+table+" g JOIN "+item.table+"s i ON i."+item.column+" = g."+item.column+" "+
+			"WHERE g.expires_at <= $1",
+		now,
+	)
+	if err != nil {
+		return err
+	}
+
+	type expiredGrant struct {
+		itemID, principalID int
+		ownerID             sql.NullInt64
+		itemName, name      sql.NullString
+	}
+	var expired []expiredGrant
+	for rows.Next() {
+		var g expiredGrant
+		if err := rows.Scan(&g.itemID, &g.principalID, &g.ownerID, &g.itemName, &g.name); err != nil {
+			rows.Close()
+			return err
+		}
+		expired = append(expired, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, g := range expired {
+		err := withTx(func(tx *sql.Tx) error {
+			// the grant may have been extended since it was read
+			res, err := tx.Exec(
+				"DELETE FROM "+table+" WHERE "+item.column+" = $1 AND "+principal+" = $2 AND expires_at <= $3",
+				g.itemID, g.principalID, now,
+			)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil || n == 0 || !g.ownerID.Valid {
+				return err
+			}
+
+			var fileID, folderID *int
+			if item.table == "File" {
+				fileID = &g.itemID
+			} else {
+				folderID = &g.itemID
+			}
+			message := fmt.Sprintf("Access of %s %q to %q expired", kind, g.name.String, g.itemName.String)
+			return notify(tx, int(g.ownerID.Int64), "share_expired", message, fileID, folderID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
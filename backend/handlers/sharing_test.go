@@ -0,0 +1,94 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/handlers"
+	"backend/models"
+)
+
+func TestSharedDownload(t *testing.T) {
+	alice, bob := newUser(t), newUser(t)
+	fileID := uploadFile(t, alice, "plan.txt", "the plan")
+	path := fmt.Sprintf("/api/files/%d", fileID)
+
+	readBody(t, request(t, "GET", path, bob.token, nil, nil), http.StatusNotFound)
+
+	readBody(t, share(t, alice, fmt.Sprintf("files/%d", fileID), bob.id, "view"), http.StatusCreated)
+	readBody(t, request(t, "GET", path, bob.token, nil, nil), http.StatusForbidden)
+
+	readBody(t, share(t, alice, fmt.Sprintf("files/%d", fileID), bob.id, "download"), http.StatusCreated)
+	if body := readBody(t, request(t, "GET", path, bob.token, nil, nil), http.StatusOK); body != "the plan" {
+		t.Errorf("shared body = %q", body)
+	}
+}
+
+func TestReshareStaysWithinOwnAccess(t *testing.T) {
+	alice, bob, carol, dave, erin := newUser(t), newUser(t), newUser(t), newUser(t), newUser(t)
+	folderID := createFolder(t, alice, 0, "team")
+	fileID := uploadFileInto(t, alice, folderID, "plan.txt", "the plan")
+	folder := fmt.Sprintf("folders/%d", folderID)
+	file := fmt.Sprintf("files/%d", fileID)
+
+	readBody(t, share(t, alice, folder, bob.id, "reshare"), http.StatusCreated)
+	readBody(t, share(t, alice, folder, dave.id, "full_control"), http.StatusCreated)
+	readBody(t, share(t, alice, file, carol.id, ""), http.StatusCreated)
+
+	readBody(t, share(t, bob, file, erin.id, "download"), http.StatusCreated)
+
+	// carol's access was removed by the owner
+	readBody(t, share(t, bob, file, carol.id, "download"), http.StatusForbidden)
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/%s/share/user/%d", file, carol.id), bob.token, nil, nil), http.StatusForbidden)
+
+	// dave inherits more access than bob has
+	readBody(t, share(t, bob, file, dave.id, "view"), http.StatusForbidden)
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/%s/share/user/%d", folder, dave.id), bob.token, nil, nil), http.StatusForbidden)
+
+	// removing the owner's lower grant would give dave back full control
+	readBody(t, share(t, alice, file, dave.id, "view"), http.StatusCreated)
+	readBody(t, share(t, bob, file, dave.id, "edit"), http.StatusCreated)
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/%s/share/user/%d", file, dave.id), bob.token, nil, nil), http.StatusForbidden)
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/%s/share/user/%d", file, dave.id), alice.token, nil, nil), http.StatusOK)
+}
+
+func TestShareExpiry(t *testing.T) {
+	alice, bob := newUser(t), newUser(t)
+	fileID := uploadFile(t, alice, "plan.txt", "the plan")
+	path := fmt.Sprintf("/api/files/%d", fileID)
+	shareUntil := func(expiresAt time.Time) *http.Response {
+		body := fmt.Sprintf(`{"user_id":%d,"access_id":%d,"expires_at":%q}`, bob.id, accessLevel(t, "download"), expiresAt.Format(time.RFC3339))
+		return request(t, "POST", path+"/share/user", alice.token, strings.NewReader(body), nil)
+	}
+
+	readBody(t, shareUntil(time.Now().Add(-time.Minute)), http.StatusBadRequest)
+	readBody(t, shareUntil(time.Now().Add(time.Hour)), http.StatusCreated)
+	readBody(t, request(t, "GET", path, bob.token, nil, nil), http.StatusOK)
+
+	// an expired grant stops working before it is purged
+	_, err := config.PostgresDB.Exec("UPDATE File_Users SET expires_at = $1 WHERE file_id = $2 AND user_id = $3",
+		time.Now().UTC().Add(-time.Minute), fileID, bob.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBody(t, request(t, "GET", path, bob.token, nil, nil), http.StatusNotFound)
+
+	if err := handlers.PurgeExpiredShares(); err != nil {
+		t.Fatal(err)
+	}
+	var grants int
+	config.PostgresDB.QueryRow("SELECT COUNT(*) FROM File_Users WHERE file_id = $1", fileID).Scan(&grants)
+	if grants != 0 {
+		t.Errorf("%d grants left after the purge", grants)
+	}
+
+	var notifications []models.Notification
+	decode(t, request(t, "GET", "/api/notifications", alice.token, nil, nil), http.StatusOK, &notifications)
+	if len(notifications) != 1 || notifications[0].Type != "share_expired" || notifications[0].FileID == nil || *notifications[0].FileID != fileID {
+		t.Errorf("notifications = %+v", notifications)
+	}
+}
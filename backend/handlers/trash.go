@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"backend/config"
+	"backend/middleware"
+	"backend/models"
+)
+
+// Deleted files keep their row, versions and shares with deleted_at set
+// and still count for quotas. They are purged when the owner empties the
+// trash or by PurgeTrash after config.TrashRetention.
+
+func GetTrash(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := config.PostgresDB.Query(`
+		SELECT
+			f.file_id, f.name, f.type, f.full_path, f.folder_id, f.create_date, f.edit_date,
+			f.version_id, f.owner_id, f.deleted_at,
+			f.sha256, f.size, b.stored_size, b.verified_at, b.verify_error
+		FROM Files f
+		LEFT JOIN Blobs b ON b.blob_key = f.mongo_file_id
+		WHERE f.owner_id = $1 AND f.deleted_at IS NOT NULL
+		ORDER BY f.deleted_at DESC
+	`, userID)
+	if err != nil {
+		http.Error(w, "Database query error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	files := []models.TrashedFile{}
+	for rows.Next() {
+		var file models.TrashedFile
+		var blob blobColumns
+		err := rows.Scan(append([]interface{}{
+			&file.FileID,
+			&file.Name,
+			&file.Type,
+			&file.FullPath,
+			&file.FolderID,
+			&file.CreateDate,
+			&file.EditDate,
+			&file.VersionID,
+			&file.OwnerID,
+			&file.DeletedAt,
+		}, blob.dest()...)...)
+		if err != nil {
+			http.Error(w, "Row scan error", http.StatusInternalServerError)
+			return
+		}
+		file.BlobMetadata = blob.metadata()
+		if config.TrashRetention > 0 {
+			purgeAt := file.DeletedAt.Add(config.TrashRetention)
+			file.PurgeAt = &purgeAt
+		}
+		files = append(files, file)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+// trashedFile checks that the file is in the caller's trash
+func trashedFile(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return 0, false
+	}
+
+	var fileID, ownerID int
+	err := config.PostgresDB.QueryRow(`
+		SELECT file_id, owner_id FROM Files WHERE file_id = $1 AND deleted_at IS NOT NULL
+	`, mux.Vars(r)["file_id"]).Scan(&fileID, &ownerID)
+	if err != nil {
+		http.Error(w, "File not found in trash", http.StatusNotFound)
+		return 0, false
+	}
+	if ownerID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	return fileID, true
+}
+
+// RestoreFile moves a file back from the trash to its original path,
+// recreating folders deleted in the meantime
+func RestoreFile(w http.ResponseWriter, r *http.Request) {
+	fileID, ok := trashedFile(w, r)
+	if !ok {
+		return
+	}
+
+	err := withTx(func(tx *sql.Tx) error {
+		var ownerID int
+		var folderID sql.NullInt64
+		var fullPath string
+		err := tx.QueryRow("SELECT owner_id, folder_id, full_path FROM Files WHERE file_id = $1", fileID).Scan(&ownerID, &folderID, &fullPath)
+		if err != nil {
+			return err
+		}
+		if !folderID.Valid {
+			folder, path, err := ensureFolder(tx, ownerID, fullPath)
+			if err == errBadFolderName {
+				folder, path, err = ensureFolder(tx, ownerID, "/")
+			}
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("UPDATE Files SET folder_id = $1, full_path = $2 WHERE file_id = $3", folder, path, fileID)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("UPDATE Files SET deleted_at = NULL WHERE file_id = $1", fileID)
+		return err
+	})
+	if err != nil {
+		http.Error(w, "Failed to restore file", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "File restored"})
+}
+
+// PurgeTrashedFile deletes a file from the trash permanently
+func PurgeTrashedFile(w http.ResponseWriter, r *http.Request) {
+	fileID, ok := trashedFile(w, r)
+	if !ok {
+		return
+	}
+
+	err := withTx(func(tx *sql.Tx) error {
+		return purgeFile(tx, fileID)
+	})
+	if err != nil {
+		http.Error(w, "Failed to delete file from DB", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "File deleted"})
+}
+
+// EmptyTrash deletes all files in the caller's trash permanently
+func EmptyTrash(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+
+	fileIDs, err := trashedFileIDs("SELECT file_id FROM Files WHERE owner_id = $1 AND deleted_at IS NOT NULL", userID)
+	if err == nil {
+		err = purgeFiles(fileIDs)
+	}
+	if err != nil {
+		http.Error(w, "Failed to empty trash", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Trash emptied",
+		"deleted": len(fileIDs),
+	})
+}
+
+// PurgeTrash deletes files that stayed in the trash longer than
+// config.TrashRetention
+func PurgeTrash() error {
+	if config.TrashRetention <= 0 {
+		return nil
+	}
+
+	fileIDs, err := trashedFileIDs("SELECT file_id FROM Files WHERE deleted_at < $1", time.Now().UTC().Add(-config.TrashRetention))
+	if err != nil {
+		return err
+	}
+	return purgeFiles(fileIDs)
+}
+
+func trashedFileIDs(query string, args ...interface{}) ([]int, error) {
+	rows, err := config.PostgresDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fileIDs []int
+	for rows.Next() {
+		var fileID int
+		if err := rows.Scan(&fileID); err != nil {
+			return nil, err
+		}
+		fileIDs = append(fileIDs, fileID)
+	}
+	return fileIDs, rows.Err()
+}
+
+// purgeFiles deletes every file in its own transaction
+func purgeFiles(fileIDs []int) error {
+	for _, fileID := range fileIDs {
+		err := withTx(func(tx *sql.Tx) error {
+			return purgeFile(tx, fileID)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
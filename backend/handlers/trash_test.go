@@ -0,0 +1,78 @@
+package handlers_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"backend/config"
+)
+
+// trashIDs returns the files in the user's trash
+func trashIDs(t *testing.T, u user) []int {
+	t.Helper()
+	var files []struct {
+		FileID int `json:"file_id"`
+	}
+	decode(t, request(t, "GET", "/api/trash", u.token, nil, nil), http.StatusOK, &files)
+	var ids []int
+	for _, f := range files {
+		ids = append(ids, f.FileID)
+	}
+	return ids
+}
+
+func TestTrashRestoreAndPurge(t *testing.T) {
+	alice := newUser(t)
+	keptID := uploadFile(t, alice, "kept.txt", "keep me")
+	purgedID := uploadFile(t, alice, "purged.txt", "forget me")
+
+	for _, id := range []int{keptID, purgedID} {
+		readBody(t, request(t, "DELETE", fmt.Sprintf("/api/files/%d", id), alice.token, nil, nil), http.StatusOK)
+		readBody(t, request(t, "GET", fmt.Sprintf("/api/files/%d", id), alice.token, nil, nil), http.StatusNotFound)
+	}
+	if ids := trashIDs(t, alice); len(ids) != 2 {
+		t.Fatalf("trash = %v, want 2 files", ids)
+	}
+
+	readBody(t, request(t, "POST", fmt.Sprintf("/api/trash/%d/restore", keptID), alice.token, nil, nil), http.StatusOK)
+	if body := readBody(t, request(t, "GET", fmt.Sprintf("/api/files/%d", keptID), alice.token, nil, nil), http.StatusOK); body != "keep me" {
+		t.Errorf("restored body = %q", body)
+	}
+
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/trash/%d", purgedID), alice.token, nil, nil), http.StatusOK)
+	readBody(t, request(t, "POST", fmt.Sprintf("/api/trash/%d/restore", purgedID), alice.token, nil, nil), http.StatusNotFound)
+	if ids := trashIDs(t, alice); len(ids) != 0 {
+		t.Errorf("trash = %v after restore and purge", ids)
+	}
+}
+
+func TestDeleteSharedFolder(t *testing.T) {
+	alice, bob := newUser(t), newUser(t)
+	folderID := createFolder(t, alice, 0, "projects")
+	subID := createFolder(t, alice, folderID, "2024")
+	fileID := uploadFileInto(t, alice, subID, "plan.txt", "the plan")
+	folder := fmt.Sprintf("/api/folders/%d", folderID)
+
+	// deleting would drop bob's share of the subfolder
+	readBody(t, share(t, alice, fmt.Sprintf("folders/%d", subID), bob.id, "view"), http.StatusCreated)
+	readBody(t, request(t, "DELETE", folder, alice.token, nil, nil), http.StatusConflict)
+	readBody(t, request(t, "GET", fmt.Sprintf("/api/files/%d", fileID), alice.token, nil, nil), http.StatusOK)
+
+	readBody(t, request(t, "DELETE", fmt.Sprintf("/api/folders/%d/share/user/%d", subID, bob.id), alice.token, nil, nil), http.StatusOK)
+	readBody(t, request(t, "DELETE", folder, alice.token, nil, nil), http.StatusOK)
+	readBody(t, request(t, "GET", folder, alice.token, nil, nil), http.StatusNotFound)
+	if ids := trashIDs(t, alice); len(ids) != 1 || ids[0] != fileID {
+		t.Fatalf("trash = %v, want [%d]", ids, fileID)
+	}
+
+	// restoring recreates the folders
+	readBody(t, request(t, "POST", fmt.Sprintf("/api/trash/%d/restore", fileID), alice.token, nil, nil), http.StatusOK)
+	var path string
+	err := config.PostgresDB.QueryRow(`
+		SELECT d.full_path FROM Files f JOIN Folders d ON d.folder_id = f.folder_id WHERE f.file_id = $1
+	`, fileID).Scan(&path)
+	if err != nil || path != "/projects/2024" {
+		t.Errorf("restored to %q, %v", path, err)
+	}
+}
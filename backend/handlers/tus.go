@@ -0,0 +1,556 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"backend/config"
+	"backend/middleware"
+)
+
+// Resumable uploads, tus 1.0.0 (https://tus.io/protocols/resumable-upload)
+// with the creation, expiration, checksum and termination extensions.
+// Received bytes are kept in config.UploadPath until the upload is complete,
+// then the file is moved to the blob store and gets its Files row.
+
+const tusVersion = "1.0.0"
+
+// 460 Checksum Mismatch from the checksum extension
+const statusChecksumMismatch = 460
+
+type tusUpload struct {
+	ID        string
+	UserID    int
+	Length    int64
+	Offset    int64
+	Name      string
+	FullPath  string
+	Type      string
+	FileID    sql.NullInt64
+	ExpiresAt time.Time
+}
+
+func tusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+}
+
+// checkTusVersion rejects requests from clients speaking another protocol version
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	tusHeaders(w)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func TusOptions(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,expiration,checksum,termination")
+	w.Header().Set("Tus-Checksum-Algorithm", "md5,sha1,sha256")
+
+	// OPTIONS is public for CORS preflight, a signed-in caller gets the
+	// same limit the creation POST enforces
+	maxSize := config.MaxUploadSize
+	if userID, ok := middleware.UserFromRequest(r); ok {
+		if limit, err := newUploadLimit(userID, userID, 1); err == nil {
+			maxSize = limit.size()
+		}
+	}
+	if maxSize > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+/*
+headers:
+Upload-Length: int
+Upload-Metadata: filename base64, folder_id base64, full_path base64, type base64
+*/
+func TusCreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := newUploadLimit(userID, userID, 1)
+	if err == nil {
+		err = limit.check(length)
+	}
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	name := metadata["filename"]
+	if name == "" {
+		name = metadata["name"]
+	}
+	if name == "" {
+		http.Error(w, "Upload-Metadata must contain filename", http.StatusBadRequest)
+		return
+	}
+	fullPath := metadata["full_path"]
+	if value := metadata["folder_id"]; value != "" {
+		folderID, err := strconv.Atoi(value)
+		if err == nil {
+			fullPath, err = folderPath(config.PostgresDB, userID, &folderID)
+		}
+		if err != nil {
+			http.Error(w, "Folder not found", http.StatusNotFound)
+			return
+		}
+	}
+	if _, err := splitPath(fullPath); err != nil {
+		http.Error(w, "Invalid full_path", http.StatusBadRequest)
+		return
+	}
+	fileType := metadata["type"]
+	if fileType == "" {
+		fileType = "file"
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	uploadID := hex.EncodeToString(idBytes)
+
+	file, err := os.Create(tusDataPath(uploadID))
+	if err != nil {
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	file.Close()
+
+	expiresAt := time.Now().UTC().Add(config.UploadExpiration)
+	_, err = config.PostgresDB.Exec(`
+		INSERT INTO Uploads (upload_id, user_id, upload_length, name, full_path, type, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, uploadID, userID, length, name, fullPath, fileType, expiresAt)
+	if err != nil {
+		os.Remove(tusDataPath(uploadID))
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	// an empty file is complete right away
+	if length == 0 {
+		upload := &tusUpload{ID: uploadID, UserID: userID, Name: name, FullPath: fullPath, Type: fileType}
+		if err := finishTusUpload(r, upload); err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		w.Header().Set("Upload-File-Id", strconv.FormatInt(upload.FileID.Int64, 10))
+	}
+
+	w.Header().Set("Location", "/api/uploads/"+uploadID)
+	w.Header().Set("Upload-Expires", expiresAt.Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func TusUploadStatus(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	upload, ok := getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.FileID.Valid {
+		w.Header().Set("Upload-File-Id", strconv.FormatInt(upload.FileID.Int64, 10))
+	} else {
+		w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+/*
+headers:
+Content-Type: application/offset+octet-stream
+Upload-Offset: int
+Upload-Checksum: algorithm base64 (optional)
+*/
+func TusPatchUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	upload, ok := getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+	if upload.FileID.Valid {
+		http.Error(w, "Upload is already complete", http.StatusConflict)
+		return
+	}
+
+	var checksum hash.Hash
+	var expectedSum []byte
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		checksum, expectedSum, err = parseUploadChecksum(header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// the chunk is received aside, concurrent PATCHes of the same offset
+	// are sorted out when it is appended
+	chunk, err := os.CreateTemp(config.UploadPath, upload.ID+"-*.chunk")
+	if err != nil {
+		http.Error(w, "Failed to open upload", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(chunk.Name())
+	defer chunk.Close()
+
+	body := &limitedReader{r: r.Body, remaining: upload.Length - upload.Offset, limited: true}
+	var dst io.Writer = chunk
+	if checksum != nil {
+		dst = io.MultiWriter(chunk, checksum)
+	}
+	written, copyErr := io.Copy(dst, body)
+
+	// a chunk with a checksum is kept only if it is complete and matches,
+	// without one everything received so far counts
+	if body.err == errUploadTooLarge {
+		http.Error(w, "Chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if checksum != nil && copyErr != nil {
+		http.Error(w, "Failed to read chunk", http.StatusBadRequest)
+		return
+	}
+	if checksum != nil && string(checksum.Sum(nil)) != string(expectedSum) {
+		http.Error(w, "Checksum mismatch", statusChecksumMismatch)
+		return
+	}
+
+	err = appendTusChunk(upload, chunk, written)
+	if err == errOffsetMismatch {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	} else if err == sql.ErrNoRows {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to write chunk", http.StatusInternalServerError)
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		if err := finishTusUpload(r, upload); err != nil {
+			writeUploadError(w, err)
+			return
+		}
+		w.Header().Set("Upload-File-Id", strconv.FormatInt(upload.FileID.Int64, 10))
+	} else {
+		w.Header().Set("Upload-Expires", upload.ExpiresAt.Format(http.TimeFormat))
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var errOffsetMismatch = errors.New("upload offset has moved")
+var errUploadFinished = errors.New("upload is already finished")
+
+// appendTusChunk writes size bytes of chunk at the upload's offset and
+// saves the new offset. The Uploads row stays locked meanwhile, so of
+// several PATCHes of one offset, from any server, only the first counts.
+func appendTusChunk(upload *tusUpload, chunk *os.File, size int64) error {
+	if _, err := chunk.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	expiresAt := time.Now().UTC().Add(config.UploadExpiration)
+
+	err := withTx(func(tx *sql.Tx) error {
+		var offset int64
+		var fileID sql.NullInt64
+		err := tx.QueryRow(`
+			SELECT upload_offset, file_id FROM Uploads WHERE upload_id = $1 FOR UPDATE
+		`, upload.ID).Scan(&offset, &fileID)
+		if err != nil {
+			return err
+		}
+		if offset != upload.Offset || fileID.Valid {
+			return errOffsetMismatch
+		}
+
+		file, err := os.OpenFile(tusDataPath(upload.ID), os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		// bytes of a chunk whose offset was not saved are dropped
+		if err := file.Truncate(offset); err != nil {
+			return err
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(file, chunk, size); err != nil {
+			return err
+		}
+		if err := file.Sync(); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE Uploads SET upload_offset = $1, expires_at = $2 WHERE upload_id = $3
+		`, offset+size, expiresAt, upload.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	upload.Offset += size
+	upload.ExpiresAt = expiresAt
+	return nil
+}
+
+func TusDeleteUpload(w http.ResponseWriter, r *http.Request) {
+	if !checkTusVersion(w, r) {
+		return
+	}
+
+	upload, ok := getTusUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if err := removeTusUpload(upload.ID); err != nil {
+		http.Error(w, "Failed to delete upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeExpiredUploads drops uploads past their Upload-Expires. Finished
+// uploads are kept until then too, so clients can still HEAD them.
+func PurgeExpiredUploads() error {
+	rows, err := config.PostgresDB.Query(`
+		SELECT upload_id FROM Uploads WHERE expires_at < $1
+	`, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := removeTusUpload(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finishTusUpload moves a complete upload to the blob store and creates
+// the file exactly like UploadFile does
+func finishTusUpload(r *http.Request, upload *tusUpload) error {
+	data, err := os.Open(tusDataPath(upload.ID))
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	quotas, err := loadQuotas(config.PostgresDB, upload.UserID)
+	if err != nil {
+		return err
+	}
+
+	blob, err := storeBlob(r.Context(), upload.Name, data)
+	if err != nil {
+		return err
+	}
+
+	// the file and the finished upload are saved together, so a retried
+	// last PATCH cannot create the file twice; the one that loses keeps
+	// the file of the first and its blob is left to the collector
+	var fileID int
+	err = withTx(func(tx *sql.Tx) error {
+		fileID, err = createFile(tx, upload.UserID, blob, upload.Name, upload.FullPath, upload.Type)
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(`
+			UPDATE Uploads SET file_id = $1 WHERE upload_id = $2 AND file_id IS NULL
+		`, fileID, upload.ID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errUploadFinished
+		}
+		// quotas may have filled up since the upload was created
+		return enforceQuotas(tx, upload.UserID, quotas)
+	})
+	if err == errUploadFinished {
+		var finished sql.NullInt64
+		err = config.PostgresDB.QueryRow("SELECT file_id FROM Uploads WHERE upload_id = $1", upload.ID).Scan(&finished)
+		if err == nil && !finished.Valid {
+			err = sql.ErrNoRows
+		}
+		fileID = int(finished.Int64)
+	}
+	if err != nil {
+		return err
+	}
+
+	upload.FileID = sql.NullInt64{Int64: int64(fileID), Valid: true}
+	os.Remove(tusDataPath(upload.ID))
+	return nil
+}
+
+// getTusUpload loads the caller's upload, answering 404 if there is none
+func getTusUpload(w http.ResponseWriter, r *http.Request) (*tusUpload, bool) {
+	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	if !ok {
+		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	var upload tusUpload
+	err := config.PostgresDB.QueryRow(`
+		SELECT upload_id, user_id, upload_length, upload_offset, name, full_path, type, file_id, expires_at
+		FROM Uploads
+		WHERE upload_id = $1
+	`, mux.Vars(r)["upload_id"]).Scan(&upload.ID, &upload.UserID, &upload.Length, &upload.Offset,
+		&upload.Name, &upload.FullPath, &upload.Type, &upload.FileID, &upload.ExpiresAt)
+	if err == sql.ErrNoRows || (err == nil && upload.UserID != userID) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !upload.FileID.Valid && upload.ExpiresAt.Before(time.Now()) {
+		http.Error(w, "Upload expired", http.StatusGone)
+		return nil, false
+	}
+
+	return &upload, true
+}
+
+func removeTusUpload(uploadID string) error {
+	_, err := config.PostgresDB.Exec("DELETE FROM Uploads WHERE upload_id = $1", uploadID)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(tusDataPath(uploadID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func tusDataPath(uploadID string) string {
+	return filepath.Join(config.UploadPath, uploadID)
+}
+
+// parseUploadMetadata decodes "key base64value,key2 base64value2"
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if header == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, fmt.Errorf("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// parseUploadChecksum decodes "algorithm base64digest"
+func parseUploadChecksum(header string) (hash.Hash, []byte, error) {
+	algorithm, encoded, _ := strings.Cut(header, " ")
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid Upload-Checksum")
+	}
+
+	switch algorithm {
+	case "md5":
+		return md5.New(), sum, nil
+	case "sha1":
+		return sha1.New(), sum, nil
+	case "sha256":
+		return sha256.New(), sum, nil
+	default:
+		return nil, nil, fmt.Errorf("Unsupported checksum algorithm")
+	}
+}
@@ -0,0 +1,128 @@
+package handlers_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// createUpload starts a tus upload of length bytes and returns its path
+func createUpload(t *testing.T, u user, name string, length int) string {
+	t.Helper()
+	header := http.Header{
+		"Tus-Resumable":   {"1.0.0"},
+		"Upload-Length":   {fmt.Sprint(length)},
+		"Upload-Metadata": {"filename " + base64.StdEncoding.EncodeToString([]byte(name))},
+	}
+	resp := request(t, "POST", "/api/uploads", u.token, nil, header)
+	readBody(t, resp, http.StatusCreated)
+	return resp.Header.Get("Location")
+}
+
+func patchUpload(t *testing.T, u user, path string, offset int, chunk string, header http.Header) *http.Response {
+	t.Helper()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Tus-Resumable", "1.0.0")
+	header.Set("Content-Type", "application/offset+octet-stream")
+	header.Set("Upload-Offset", fmt.Sprint(offset))
+	return request(t, "PATCH", path, u.token, strings.NewReader(chunk), header)
+}
+
+func TestTusUpload(t *testing.T) {
+	alice := newUser(t)
+	content := "hello, resumable world"
+	path := createUpload(t, alice, "hello.txt", len(content))
+
+	readBody(t, patchUpload(t, alice, path, 0, content[:5], nil), http.StatusNoContent)
+	readBody(t, patchUpload(t, alice, path, 0, content[:5], nil), http.StatusConflict)
+
+	sum := sha256.Sum256([]byte("not the chunk"))
+	bad := http.Header{"Upload-Checksum": {"sha256 " + base64.StdEncoding.EncodeToString(sum[:])}}
+	readBody(t, patchUpload(t, alice, path, 5, content[5:], bad), 460)
+
+	resp := request(t, "HEAD", path, alice.token, nil, http.Header{"Tus-Resumable": {"1.0.0"}})
+	readBody(t, resp, http.StatusOK)
+	if offset := resp.Header.Get("Upload-Offset"); offset != "5" {
+		t.Errorf("Upload-Offset after a bad checksum = %s, want 5", offset)
+	}
+
+	sum = sha256.Sum256([]byte(content[5:]))
+	good := http.Header{"Upload-Checksum": {"sha256 " + base64.StdEncoding.EncodeToString(sum[:])}}
+	resp = patchUpload(t, alice, path, 5, content[5:], good)
+	readBody(t, resp, http.StatusNoContent)
+	fileID := resp.Header.Get("Upload-File-Id")
+	if body := readBody(t, request(t, "GET", "/api/files/"+fileID, alice.token, nil, nil), http.StatusOK); body != content {
+		t.Errorf("uploaded body = %q", body)
+	}
+	readBody(t, patchUpload(t, alice, path, len(content), "", nil), http.StatusConflict)
+}
+
+func TestTusConcurrentPatches(t *testing.T) {
+	alice := newUser(t)
+	content := strings.Repeat("0123456789", 1000)
+	path := createUpload(t, alice, "race.txt", len(content))
+
+	// only one of several PATCHes of the same offset is appended, all of
+	// them pass the first offset check before their bodies arrive
+	statuses := make(chan int, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(statuses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("PATCH", server.URL+path, &slowReader{r: strings.NewReader(content)})
+			req.ContentLength = int64(len(content))
+			req.Header.Set("Authorization", "Bearer "+alice.token)
+			req.Header.Set("Tus-Resumable", "1.0.0")
+			req.Header.Set("Content-Type", "application/offset+octet-stream")
+			req.Header.Set("Upload-Offset", "0")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusNoContent] != 1 || counts[http.StatusConflict] != cap(statuses)-1 {
+		t.Errorf("statuses = %v, want one 204 and the rest 409", counts)
+	}
+
+	var files []struct {
+		Name string `json:"name"`
+	}
+	decode(t, request(t, "GET", "/api/files", alice.token, nil, nil), http.StatusOK, &files)
+	if len(files) != 1 {
+		t.Errorf("%d files created, want 1", len(files))
+	}
+}
+
+// slowReader holds back its content for a while
+type slowReader struct {
+	r       io.Reader
+	started bool
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	if !s.started {
+		s.started = true
+		time.Sleep(200 * time.Millisecond)
+	}
+	return s.r.Read(p)
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"database/sql"
+
+	"backend/config"
+)
+
+// withTx runs fn in a transaction and commits it if fn returns nil.
+// In embedded mode the transaction holds the SQLite write lock, so fn must
+// only use tx, never config.PostgresDB.
+func withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := config.PostgresDB.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
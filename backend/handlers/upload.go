@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+
+	"backend/config"
+)
+
+var errUploadTooLarge = errors.New("upload exceeds the size limit")
+var errBadUpload = errors.New("malformed upload")
+
+// streamedUpload is a multipart request whose "file" part was written
+// straight to the blob store. Blob.Key is empty if there was no file part.
+type streamedUpload struct {
+	Blob     storedBlob
+	Filename string
+	Fields   map[string]string
+}
+
+// partFilter checks the file part before it is stored, given its file name
+// and the fields that came before it
+type partFilter func(filename string, fields map[string]string) error
+
+// streamUpload reads a multipart request part by part, so memory use does not
+// depend on the file size. If accept is not nil it can reject the file
+// before any of it is stored.
+func streamUpload(r *http.Request, limit uploadLimit, accept partFilter) (*streamedUpload, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errBadUpload
+	}
+
+	// a blob stored before a failure stays pending and is collected later
+	upload := &streamedUpload{Fields: map[string]string{}}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errBadUpload
+		}
+
+		if part.FormName() != "file" || part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, 1<<20))
+			part.Close()
+			if err != nil {
+				return nil, errBadUpload
+			}
+			upload.Fields[part.FormName()] = string(value)
+			continue
+		}
+
+		// only the first file is stored
+		if upload.Blob.Key != "" {
+			part.Close()
+			continue
+		}
+
+		if accept != nil {
+			if err := accept(part.FileName(), upload.Fields); err != nil {
+				part.Close()
+				return nil, err
+			}
+		}
+
+		maxSize := limit.size()
+		body := &limitedReader{r: part, remaining: maxSize, limited: maxSize > 0}
+		blob, err := storeBlob(r.Context(), part.FileName(), body)
+		part.Close()
+		if body.err == errUploadTooLarge {
+			return nil, limit.check(maxSize + 1)
+		} else if body.err != nil {
+			return nil, errBadUpload
+		} else if err != nil {
+			return nil, err
+		}
+		upload.Blob = blob
+		upload.Filename = part.FileName()
+	}
+
+	return upload, nil
+}
+
+// writeUploadError reports an upload error with a matching status
+func writeUploadError(w http.ResponseWriter, err error) {
+	if qe, ok := err.(*quotaError); ok {
+		http.Error(w, qe.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	switch err {
+	case errUploadTooLarge:
+		http.Error(w, "File is too large", http.StatusRequestEntityTooLarge)
+	case errBadUpload:
+		http.Error(w, "File upload error", http.StatusBadRequest)
+	default:
+		http.Error(w, "Saving file error", http.StatusInternalServerError)
+	}
+}
+
+// maxUploadSize returns the limit of the user's role, or the default one
+func maxUploadSize(userID int) (int64, error) {
+	var roleLimit sql.NullInt64
+	err := config.PostgresDB.QueryRow(`
+		SELECT r.max_upload_size
+		FROM Users u
+		LEFT JOIN Roles r ON r.role_id = u.role_id
+		WHERE u.user_id = $1
+	`, userID).Scan(&roleLimit)
+	if err != nil {
+		return 0, err
+	}
+
+	if roleLimit.Valid {
+		return roleLimit.Int64, nil
+	}
+	return config.MaxUploadSize, nil
+}
+
+// limitedReader fails with errUploadTooLarge instead of silently truncating,
+// and remembers read errors so they are not mistaken for storage errors
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	limited   bool
+	err       error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.limited && l.remaining <= 0 {
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			l.err = errUploadTooLarge
+			return 0, l.err
+		}
+		if err != nil && err != io.EOF {
+			l.err = err
+		}
+		return 0, err
+	}
+
+	if l.limited && int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if err != nil && err != io.EOF {
+		l.err = err
+	}
+	return n, err
+}
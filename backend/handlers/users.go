@@ -63,11 +63,11 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 
 	var user models.User
 	query := `
-		SELECT user_id, mail, login, name, surname, type, role_id, group_id
+		SELECT user_id, mail, login, name, surname, type, role_id, group_id, quota_bytes, quota_files
 		FROM Users
 		WHERE user_id = $1
 	`
-	err := config.PostgresDB.QueryRow(query, userID).Scan(&user.ID, &user.Mail, &user.Login, &user.Name, &user.Surname, &user.Type, &user.RoleID, &user.GroupID)
+	err := config.PostgresDB.QueryRow(query, userID).Scan(&user.ID, &user.Mail, &user.Login, &user.Name, &user.Surname, &user.Type, &user.RoleID, &user.GroupID, &user.QuotaBytes, &user.QuotaFiles)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "User not found", http.StatusNotFound)
@@ -110,11 +110,11 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 		}
 
 		rows, err = config.PostgresDB.Query(`
-			SELECT user_id, login, mail, name, surname, type, role_id, group_id 
+			SELECT user_id, login, mail, name, surname, type, role_id, group_id, quota_bytes, quota_files
 			FROM Users WHERE group_id = $1`, groupID)
 	} else {
 		rows, err = config.PostgresDB.Query(`
-			SELECT user_id, login, mail, name, surname, type, role_id, group_id 
+			SELECT user_id, login, mail, name, surname, type, role_id, group_id, quota_bytes, quota_files
 			FROM Users`)
 	}
 
@@ -126,7 +126,7 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 
 	for rows.Next() {
 		var user models.User
-		err := rows.Scan(&user.ID, &user.Login, &user.Mail, &user.Name, &user.Surname, &user.Type, &user.RoleID, &user.GroupID)
+		err := rows.Scan(&user.ID, &user.Login, &user.Mail, &user.Name, &user.Surname, &user.Type, &user.RoleID, &user.GroupID, &user.QuotaBytes, &user.QuotaFiles)
 		if err != nil {
 			http.Error(w, "Error scanning users", http.StatusInternalServerError)
 			return
@@ -144,6 +144,7 @@ password: string
 mail: string
 name: string
 surname: string
+role_id, group_id, quota_bytes, quota_files: int (manage_users only)
 */
 func UpdateUser(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -207,7 +208,7 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		index++
 	}
 
-	// admin and manage_users can change fields: role_id и group_id
+	// admin and manage_users can change fields: role_id, group_id and quotas
 	if updateData.RoleID != nil && canManageUsers {
 		fields = append(fields, fmt.Sprintf("role_id = $%d", index))
 		values = append(values, *updateData.RoleID)
@@ -218,6 +219,16 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		values = append(values, *updateData.GroupID)
 		index++
 	}
+	if updateData.QuotaBytes != nil && canManageUsers {
+		fields = append(fields, fmt.Sprintf("quota_bytes = $%d", index))
+		values = append(values, *updateData.QuotaBytes)
+		index++
+	}
+	if updateData.QuotaFiles != nil && canManageUsers {
+		fields = append(fields, fmt.Sprintf("quota_files = $%d", index))
+		values = append(values, *updateData.QuotaFiles)
+		index++
+	}
 
 	if len(fields) == 0 {
 		http.Error(w, "No fields to update", http.StatusBadRequest)
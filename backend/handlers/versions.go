@@ -4,19 +4,13 @@ import (
 	"backend/config"
 	"backend/middleware"
 	"backend/models"
-	"bytes"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/gridfs"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func generateUniqueVersionName(baseName string, fileID int) (string, error) {
@@ -73,75 +67,23 @@ func CreateFileVersion(w http.ResponseWriter, r *http.Request) {
 		requestedName = "version"
 	}
 
-	// check if user is owner
-	var ownerID int
-	err = config.PostgresDB.QueryRow(`
-        SELECT owner_id FROM Files WHERE file_id = $1
-    `, fileID).Scan(&ownerID)
-	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
-
-	if ownerID != userID {
-		http.Error(w, "You are not the owner of the file", http.StatusForbidden)
+	auth, ok := authorizeFileID(w, r, fileID, accessManageVersions)
+	if !ok {
 		return
 	}
 
-	// get current mongo_file_id
-	var currentMongoFileID string
+	// get current blob key, the version shares it with the file
+	var currentBlobKey string
+	var sha256 sql.NullString
+	var size sql.NullInt64
 	err = config.PostgresDB.QueryRow(`
-        SELECT mongo_file_id FROM Files WHERE file_id = $1
-    `, fileID).Scan(&currentMongoFileID)
+        SELECT mongo_file_id, sha256, size FROM Files WHERE file_id = $1
+    `, fileID).Scan(&currentBlobKey, &sha256, &size)
 	if err != nil {
 		http.Error(w, "Failed to get current file data", http.StatusInternalServerError)
 		return
 	}
 
-	// create new mongo copy
-	bucket, err := gridfs.NewBucket(config.DB)
-	if err != nil {
-		http.Error(w, "GridFS initialization error", http.StatusInternalServerError)
-		return
-	}
-
-	sourceID, err := primitive.ObjectIDFromHex(currentMongoFileID)
-	if err != nil {
-		http.Error(w, "Invalid MongoDB file ID", http.StatusInternalServerError)
-		return
-	}
-
-	downloadStream, err := bucket.OpenDownloadStream(sourceID)
-	if err != nil {
-		http.Error(w, "Failed to open source file", http.StatusInternalServerError)
-		return
-	}
-	defer downloadStream.Close()
-
-	buf := bytes.NewBuffer(nil)
-	if _, err := io.Copy(buf, downloadStream); err != nil {
-		http.Error(w, "Failed to read source file", http.StatusInternalServerError)
-		return
-	}
-
-	uploadStream, err := bucket.OpenUploadStream(
-		requestedName,
-		options.GridFSUpload().SetMetadata(bson.M{"owner_id": userID}),
-	)
-	if err != nil {
-		http.Error(w, "Failed to create new file in MongoDB", http.StatusInternalServerError)
-		return
-	}
-	defer uploadStream.Close()
-
-	if _, err := io.Copy(uploadStream, bytes.NewReader(buf.Bytes())); err != nil {
-		http.Error(w, "Failed to upload new file", http.StatusInternalServerError)
-		return
-	}
-
-	newMongoID := uploadStream.FileID.(primitive.ObjectID)
-	newMongoFileIDStr := newMongoID.Hex()
-
 	// generate new uniq version name
 	uniqueName, err := generateUniqueVersionName(requestedName, fileID)
 	if err != nil {
@@ -149,25 +91,38 @@ func CreateFileVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// create new version
-	var versionID int
-	err = config.PostgresDB.QueryRow(`
-        INSERT INTO FileVersions (file_id, user_id, name, mongo_file_id)
-        VALUES ($1, $2, $3, $4)
-        RETURNING version_id
-    `, fileID, userID, uniqueName, newMongoFileIDStr).Scan(&versionID)
+	quotas, err := loadQuotas(config.PostgresDB, auth.OwnerID)
 	if err != nil {
-		http.Error(w, "Failed to create file version", http.StatusInternalServerError)
+		http.Error(w, "Failed to get quota", http.StatusInternalServerError)
 		return
 	}
 
-	// update curent file version
-	_, err = config.PostgresDB.Exec(`
-        UPDATE Files SET version_id = $1, mongo_file_id = $2, edit_date = CURRENT_TIMESTAMP
-        WHERE file_id = $3
-    `, versionID, newMongoFileIDStr, fileID)
-	if err != nil {
-		http.Error(w, "Failed to update file with new version", http.StatusInternalServerError)
+	// create new version and make it current
+	var versionID int
+	err = withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`
+            INSERT INTO FileVersions (file_id, user_id, name, mongo_file_id, sha256, size)
+            VALUES ($1, $2, $3, $4, $5, $6)
+            RETURNING version_id
+        `, fileID, userID, uniqueName, currentBlobKey, sha256, size).Scan(&versionID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+            UPDATE Files SET version_id = $1, edit_date = CURRENT_TIMESTAMP
+            WHERE file_id = $2
+        `, versionID, fileID)
+		if err != nil {
+			return err
+		}
+		return enforceQuotas(tx, auth.OwnerID, quotas)
+	})
+	if _, ok := err.(*quotaError); ok {
+		writeUploadError(w, err)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to create file version", http.StatusInternalServerError)
 		return
 	}
 
@@ -179,42 +134,32 @@ func CreateFileVersion(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetFileVersions(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	auth, ok := authorizeFile(w, r, accessView)
 	if !ok {
-		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
-		return
-	}
-
-	vars := mux.Vars(r)
-	fileID, err := strconv.Atoi(vars["file_id"])
-	if err != nil {
-		http.Error(w, "Invalid file ID", http.StatusBadRequest)
 		return
 	}
+	fileID := auth.FileID
 
-	// check if user is owner
-	var ownerID, currentVersionID int
-	err = config.PostgresDB.QueryRow(`
-		SELECT owner_id, version_id 
+	var currentVersionID int
+	err := config.PostgresDB.QueryRow(`
+		SELECT version_id 
 		FROM Files 
 		WHERE file_id = $1
-	`, fileID).Scan(&ownerID, &currentVersionID)
+	`, fileID).Scan(&currentVersionID)
 
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
-	if ownerID != userID {
-		http.Error(w, "Access denied", http.StatusForbidden)
-		return
-	}
 
 	// get versions
 	rows, err := config.PostgresDB.Query(`
-		SELECT version_id, name, create_date, edit_date
-		FROM FileVersions
-		WHERE file_id = $1
-		ORDER BY create_date DESC
+		SELECT v.version_id, v.name, v.create_date, v.edit_date,
+			v.sha256, v.size, b.stored_size, b.verified_at, b.verify_error
+		FROM FileVersions v
+		LEFT JOIN Blobs b ON b.blob_key = v.mongo_file_id
+		WHERE v.file_id = $1
+		ORDER BY v.create_date DESC
 	`, fileID)
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -225,11 +170,13 @@ func GetFileVersions(w http.ResponseWriter, r *http.Request) {
 	var versions []models.FileVersion
 	for rows.Next() {
 		var v models.FileVersion
-		err := rows.Scan(&v.VersionID, &v.Name, &v.CreateDate, &v.EditDate)
+		var blob blobColumns
+		err := rows.Scan(append([]interface{}{&v.VersionID, &v.Name, &v.CreateDate, &v.EditDate}, blob.dest()...)...)
 		if err != nil {
 			http.Error(w, "Database error", http.StatusInternalServerError)
 			return
 		}
+		v.BlobMetadata = blob.metadata()
 		v.IsCurrent = (v.VersionID == currentVersionID)
 		versions = append(versions, v)
 	}
@@ -239,12 +186,6 @@ func GetFileVersions(w http.ResponseWriter, r *http.Request) {
 }
 
 func UpdateFileVersionName(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
-	if !ok {
-		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
-		return
-	}
-
 	vars := mux.Vars(r)
 	versionID, err := strconv.Atoi(vars["version_id"])
 	if err != nil {
@@ -252,17 +193,19 @@ func UpdateFileVersionName(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// check if user owns the version
-	var dbUserID int
+	// check write access to the version's file
+	var fileID int
 	err = config.PostgresDB.QueryRow(`
-		SELECT user_id FROM FileVersions WHERE version_id = $1
-	`, versionID).Scan(&dbUserID)
+		SELECT v.file_id
+		FROM FileVersions v
+		JOIN Files f ON f.file_id = v.file_id
+		WHERE v.version_id = $1 AND f.deleted_at IS NULL
+	`, versionID).Scan(&fileID)
 	if err != nil {
 		http.Error(w, "Version not found", http.StatusNotFound)
 		return
 	}
-	if dbUserID != userID {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	if _, ok := authorizeFileID(w, r, fileID, accessManageVersions); !ok {
 		return
 	}
 
@@ -297,12 +240,6 @@ func UpdateFileVersionName(w http.ResponseWriter, r *http.Request) {
 }
 
 func DeleteFileVersion(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
-	if !ok {
-		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
-		return
-	}
-
 	vars := mux.Vars(r)
 	versionID, err := strconv.Atoi(vars["version_id"])
 	if err != nil {
@@ -311,20 +248,18 @@ func DeleteFileVersion(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// get version
-	var mongoFileID string
-	var dbUserID int
+	var blobKey string
 	var fileID int
 	err = config.PostgresDB.QueryRow(`
-		SELECT user_id, mongo_file_id, file_id
+		SELECT mongo_file_id, file_id
 		FROM FileVersions
 		WHERE version_id = $1
-	`, versionID).Scan(&dbUserID, &mongoFileID, &fileID)
+	`, versionID).Scan(&blobKey, &fileID)
 	if err != nil {
 		http.Error(w, "Version not found", http.StatusNotFound)
 		return
 	}
-	if dbUserID != userID {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	if _, ok := authorizeFileID(w, r, fileID, accessManageVersions); !ok {
 		return
 	}
 
@@ -333,7 +268,7 @@ func DeleteFileVersion(w http.ResponseWriter, r *http.Request) {
 	err = config.PostgresDB.QueryRow(`
 		SELECT version_id
 		FROM Files
-		WHERE file_id = $1
+		WHERE file_id = $1 AND deleted_at IS NULL
 	`, fileID).Scan(&currentVersionID)
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
@@ -344,30 +279,17 @@ func DeleteFileVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bucket, err := gridfs.NewBucket(config.DB)
-	if err != nil {
-		http.Error(w, "GridFS initialization error", http.StatusInternalServerError)
-		return
-	}
-
-	objectID, err := primitive.ObjectIDFromHex(mongoFileID)
-	if err != nil {
-		http.Error(w, "Invalid mongo file ID", http.StatusInternalServerError)
-		return
-	}
-
-	// deelte mongo file
-	err = bucket.Delete(objectID)
-	if err != nil {
-		http.Error(w, "Failed to delete file from storage", http.StatusInternalServerError)
-		return
-	}
-
-	// delete version
-	_, err = config.PostgresDB.Exec(`
-		DELETE FROM FileVersions
-		WHERE version_id = $1
-	`, versionID)
+	// delete version, the collector deletes its blob if nothing else refers to it
+	err = withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM FileVersions
+			WHERE version_id = $1
+		`, versionID)
+		if err != nil {
+			return err
+		}
+		return releaseBlob(tx, blobKey)
+	})
 	if err != nil {
 		http.Error(w, "Failed to delete version from database", http.StatusInternalServerError)
 		return
@@ -380,53 +302,32 @@ func DeleteFileVersion(w http.ResponseWriter, r *http.Request) {
 }
 
 func UpdateFileCurrentVersion(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDKey).(int)
+	auth, ok := authorizeFile(w, r, accessManageVersions)
 	if !ok {
-		http.Error(w, "Failed to get user ID", http.StatusUnauthorized)
-		return
-	}
-
-	vars := mux.Vars(r)
-	fileID, err := strconv.Atoi(vars["file_id"])
-	if err != nil {
-		http.Error(w, "Invalid file ID", http.StatusBadRequest)
 		return
 	}
+	fileID := auth.FileID
 
 	type RequestBody struct {
 		VersionID int `json:"version_id"`
 	}
 	var reqBody RequestBody
-	err = json.NewDecoder(r.Body).Decode(&reqBody)
+	err := json.NewDecoder(r.Body).Decode(&reqBody)
 	if err != nil || reqBody.VersionID == 0 {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// check the existence of the file and belonging to the user
-	var ownerID int
-	err = config.PostgresDB.QueryRow(`
-		SELECT owner_id
-		FROM Files
-		WHERE file_id = $1
-	`, fileID).Scan(&ownerID)
-	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
-	if ownerID != userID {
-		http.Error(w, "Access denied", http.StatusForbidden)
-		return
-	}
-
-	// get the mongo_file_id from the selected version
+	// get the blob key from the selected version
 	var versionFileID int
-	var newMongoFileID string
+	var newBlobKey string
+	var sha256 sql.NullString
+	var size sql.NullInt64
 	err = config.PostgresDB.QueryRow(`
-		SELECT file_id, mongo_file_id
+		SELECT file_id, mongo_file_id, sha256, size
 		FROM FileVersions
 		WHERE version_id = $1
-	`, reqBody.VersionID).Scan(&versionFileID, &newMongoFileID)
+	`, reqBody.VersionID).Scan(&versionFileID, &newBlobKey, &sha256, &size)
 	if err != nil {
 		http.Error(w, "Version not found", http.StatusNotFound)
 		return
@@ -436,12 +337,25 @@ func UpdateFileCurrentVersion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// update current version and mongo_file_id in Files table
-	_, err = config.PostgresDB.Exec(`
-		UPDATE Files
-		SET version_id = $1, mongo_file_id = $2, edit_date = NOW()
-		WHERE file_id = $3
-	`, reqBody.VersionID, newMongoFileID, fileID)
+	// update current version and mongo_file_id in Files table; the replaced
+	// blob may have been uploaded without a version of its own
+	err = withTx(func(tx *sql.Tx) error {
+		var oldBlobKey string
+		err := tx.QueryRow("SELECT mongo_file_id FROM Files WHERE file_id = $1 FOR UPDATE", fileID).Scan(&oldBlobKey)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			UPDATE Files
+			SET version_id = $1, mongo_file_id = $2, sha256 = $3, size = $4, edit_date = NOW()
+			WHERE file_id = $5
+		`, reqBody.VersionID, newBlobKey, sha256, size, fileID)
+		if err != nil {
+			return err
+		}
+		return releaseBlob(tx, oldBlobKey)
+	})
 	if err != nil {
 		http.Error(w, "Failed to update current version", http.StatusInternalServerError)
 		return
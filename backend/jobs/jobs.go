@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"backend/handlers"
+)
+
+// Start runs the background maintenance jobs
+func Start() {
+	go every(time.Hour, "expired uploads", handlers.PurgeExpiredUploads)
+	go every(time.Hour, "trash", handlers.PurgeTrash)
+	go every(10*time.Minute, "orphan blobs", handlers.CollectOrphanBlobs)
+	go every(10*time.Minute, "integrity scrub", handlers.ScrubBlobs)
+	go every(10*time.Minute, "expired shares", handlers.PurgeExpiredShares)
+}
+
+func every(interval time.Duration, name string, job func() error) {
+	for {
+		if err := job(); err != nil {
+			log.Println("❌ Ошибка фоновой задачи", name+":", err)
+		}
+		time.Sleep(interval)
+	}
+}
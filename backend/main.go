@@ -4,14 +4,42 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"backend/config"
+	"backend/jobs"
 	"backend/routes"
 )
 
 func main() {
+	config.LoadSettings()
+
+	// admin commands keep stdout for their output
+	stdout := os.Stdout
+	if len(os.Args) > 1 {
+		os.Stdout = os.Stderr
+	}
 	config.ConnectDB()
 
+	// admin commands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rotate-key":
+			rotateKey()
+		case "check":
+			check(stdout, os.Args[2:])
+		case "migrate-folders":
+			migrateFolders()
+		case "backfill-blobs":
+			backfillBlobs()
+		default:
+			log.Fatal("Неизвестная команда: ", os.Args[1])
+		}
+		return
+	}
+
+	jobs.Start()
+
 	r := routes.RegisterRoutes()
 
 	port := ":8080"
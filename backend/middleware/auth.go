@@ -30,24 +30,38 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := &CustomClaims{}
-
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-
-		if err != nil || !token.Valid {
+		userID, ok := UserFromRequest(r)
+		if !ok {
 			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+		ctx := context.WithValue(r.Context(), UserIDKey, userID)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// UserFromRequest returns the user of a valid bearer token, for routes
+// that also answer without one
+func UserFromRequest(r *http.Request) (int, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return 0, false
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	claims := &CustomClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	})
+	if err != nil || !token.Valid {
+		return 0, false
+	}
+	return claims.UserID, true
+}
+
 func CheckPermission(userID int, permission string) (bool, error) {
 	// Check user for admin permission
 	var userType string
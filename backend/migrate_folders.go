@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"backend/handlers"
+)
+
+// migrateFolders creates folders from the full_path of files uploaded before
+// folders existed. It is safe to run more than once.
+func migrateFolders() {
+	placed, replaced, err := handlers.MigrateFolders()
+	if err != nil {
+		log.Fatal("Ошибка переноса папок:", err)
+	}
+	fmt.Printf("✅ Файлов перенесено в папки: %d, файлов-папок заменено папками: %d\n", placed, replaced)
+}
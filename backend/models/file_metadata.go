@@ -1,14 +1,36 @@
 package models
 
+import "time"
+
 type FileMetadata struct {
 	FileID     int    `json:"file_id"`
 	Name       string `json:"name"`
 	Type       string `json:"type"`
 	FullPath   string `json:"full_path"`
+	FolderID   *int   `json:"folder_id"`
 	CreateDate string `json:"create_date"`
 	EditDate   string `json:"edit_date"`
 	VersionID  int    `json:"version_id"`
 	OwnerID    *int   `json:"owner_id"`
+	BlobMetadata
+}
+
+// BlobMetadata describes the stored content of a file or version. The
+// fields are nil for content stored before they were recorded.
+type BlobMetadata struct {
+	SHA256           *string  `json:"sha256"`
+	Size             *int64   `json:"size"`
+	StoredSize       *int64   `json:"stored_size"`
+	CompressionRatio *float64 `json:"compression_ratio"` // size divided by stored size
+	VerifiedAt       *string  `json:"verified_at"`       // last integrity scrub
+	Corrupted        bool     `json:"corrupted"`
+}
+
+// TrashedFile is a deleted file that can still be restored
+type TrashedFile struct {
+	FileMetadata
+	DeletedAt time.Time  `json:"deleted_at"`
+	PurgeAt   *time.Time `json:"purge_at"` // nil if the trash is kept until emptied
 }
 
 type SharedFile struct {
@@ -20,7 +42,7 @@ type SharedFile struct {
 	CreateDate string `json:"create_date"`
 	EditDate   string `json:"edit_date"`
 	VersionID  int    `json:"version_id"`
-	AccessID   int    `json:"access_id"`
+	Access     string `json:"access"` // name of the caller's access level
 }
 
 type FileVersion struct {
@@ -29,4 +51,5 @@ type FileVersion struct {
 	CreateDate string `json:"create_date"`
 	EditDate   string `json:"edit_date"`
 	IsCurrent  bool   `json:"is_current"`
+	BlobMetadata
 }
@@ -1,11 +1,41 @@
 package models
 
+import "time"
+
+// AccessID is nil for an override that removes inherited access
 type FilePermissionUser struct {
-	UserID   int `json:"user_id"`
-	AccessID int `json:"access_id"`
+	UserID    int        `json:"user_id"`
+	AccessID  *int       `json:"access_id"`
+	Access    string     `json:"access,omitempty"` // name of the access level
+	ExpiresAt *time.Time `json:"expires_at"`       // nil never expires
 }
 
 type FilePermissionGroup struct {
-	GroupID  int `json:"group_id"`
-	AccessID int `json:"access_id"`
+	GroupID   int        `json:"group_id"`
+	AccessID  *int       `json:"access_id"`
+	Access    string     `json:"access,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// AccessGrant is access given to a user or group on a file, or on a folder
+// and inherited by what is below it
+type AccessGrant struct {
+	UserID     *int       `json:"user_id,omitempty"`
+	GroupID    *int       `json:"group_id,omitempty"`
+	AccessID   *int       `json:"access_id"`
+	Access     string     `json:"access,omitempty"` // name of the access level
+	Level      int        `json:"-"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	Source     string     `json:"source"` // "file" or "folder"
+	FolderID   *int       `json:"folder_id,omitempty"`
+	FolderPath string     `json:"folder_path,omitempty"`
+	Overridden bool       `json:"overridden"` // a nearer grant to the same user or group wins
+}
+
+// Access is a level of access that can be granted, higher levels include
+// the capabilities of lower ones
+type Access struct {
+	AccessID int    `json:"access_id"`
+	Name     string `json:"name"`
+	Level    int    `json:"level"`
 }
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// FileRequest is an upload only link to a folder, opened at /r/{token}
+type FileRequest struct {
+	RequestID    int        `json:"request_id"`
+	Token        string     `json:"token"`
+	FolderID     int        `json:"folder_id"`
+	CreatedBy    int        `json:"created_by"`
+	Title        string     `json:"title"`
+	MaxFileSize  *int64     `json:"max_file_size"` // nil uses the creator's upload limit
+	AllowedTypes []string   `json:"allowed_types"` // extensions, empty allows any
+	Deadline     *time.Time `json:"deadline"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	CreateDate   time.Time  `json:"create_date"`
+}
+
+// PublicFileRequest is what a file request shows to uploaders
+type PublicFileRequest struct {
+	Title        string     `json:"title"`
+	MaxFileSize  *int64     `json:"max_file_size"` // nil is unlimited
+	AllowedTypes []string   `json:"allowed_types"`
+	Deadline     *time.Time `json:"deadline"`
+}
+
+// FileRequestUpload is a file uploaded through a file request
+type FileRequestUpload struct {
+	FileID       int       `json:"file_id"`
+	Name         string    `json:"name"`
+	Size         *int64    `json:"size"`
+	UploaderName string    `json:"uploader_name"`
+	UploaderMail string    `json:"uploader_mail"`
+	CreateDate   time.Time `json:"create_date"`
+}
@@ -0,0 +1,12 @@
+package models
+
+type Folder struct {
+	FolderID   int       `json:"folder_id"`
+	OwnerID    int       `json:"owner_id"`
+	ParentID   *int      `json:"parent_id"` // nil at the top level
+	Name       string    `json:"name"`
+	FullPath   string    `json:"full_path"`
+	CreateDate string    `json:"create_date"`
+	EditDate   string    `json:"edit_date"`
+	Children   []*Folder `json:"children,omitempty"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type Notification struct {
+	NotificationID int        `json:"notification_id"`
+	Type           string     `json:"type"` // "share_expired" or "file_request_upload"
+	Message        string     `json:"message"`
+	FileID         *int       `json:"file_id"`
+	FolderID       *int       `json:"folder_id"`
+	CreateDate     time.Time  `json:"create_date"`
+	ReadAt         *time.Time `json:"read_at"`
+}
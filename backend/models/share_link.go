@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// ShareLink is a public link to a file or folder, opened at /s/{token}
+type ShareLink struct {
+	LinkID        int        `json:"link_id"`
+	Token         string     `json:"token"`
+	FileID        *int       `json:"file_id"`
+	FolderID      *int       `json:"folder_id"`
+	CreatedBy     int        `json:"created_by"`
+	HasPassword   bool       `json:"has_password"`
+	Mode          string     `json:"mode"` // "view" or "download"
+	ExpiresAt     *time.Time `json:"expires_at"`
+	MaxDownloads  *int       `json:"max_downloads"` // nil is unlimited
+	DownloadCount int        `json:"download_count"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+	CreateDate    time.Time  `json:"create_date"`
+}
+
+// PublicLink is what a share link shows to people without an account
+type PublicLink struct {
+	Type          string         `json:"type"` // "file" or "folder"
+	Name          string         `json:"name"`
+	Mode          string         `json:"mode"`
+	Size          *int64         `json:"size,omitempty"`
+	ExpiresAt     *time.Time     `json:"expires_at"`
+	DownloadsLeft *int           `json:"downloads_left"` // nil is unlimited
+	Folders       []PublicFolder `json:"folders,omitempty"`
+	Files         []PublicFile   `json:"files,omitempty"`
+}
+
+type PublicFolder struct {
+	FolderID int    `json:"folder_id"`
+	Name     string `json:"name"`
+}
+
+type PublicFile struct {
+	FileID   int    `json:"file_id"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Size     *int64 `json:"size"`
+	EditDate string `json:"edit_date"`
+}
@@ -14,6 +14,9 @@ type User struct {
 	Type     string `json:"type"`
 	RoleID   *int   `json:"role_id"`
 	GroupID  *int   `json:"group_id"`
+
+	QuotaBytes *int64 `json:"quota_bytes"` // 0 is unlimited
+	QuotaFiles *int64 `json:"quota_files"`
 }
 
 func (u *User) HashPassword() error {
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"backend/config"
+)
+
+// rotateKey re-wraps every data key that is not wrapped with the current
+// master key. Run it with the new key in ENCRYPTION_KEY and the old one in
+// ENCRYPTION_PREVIOUS_KEY; content is not re-encrypted. Rows are updated one
+// by one, so an interrupted rotation can simply be started again.
+func rotateKey() {
+	if config.Keyring == nil {
+		log.Fatal("ENCRYPTION_KEY не задан")
+	}
+	currentID := config.Keyring.CurrentID()
+
+	rows, err := config.PostgresDB.Query(`
+		SELECT blob_key, data_key, master_key_id FROM BlobKeys
+		WHERE master_key_id <> $1
+	`, currentID)
+	if err != nil {
+		log.Fatal("Ошибка чтения ключей:", err)
+	}
+
+	type wrappedKey struct{ blobKey, dataKey, keyID string }
+	var keys []wrappedKey
+	for rows.Next() {
+		var k wrappedKey
+		if err := rows.Scan(&k.blobKey, &k.dataKey, &k.keyID); err != nil {
+			log.Fatal("Ошибка чтения ключей:", err)
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Fatal("Ошибка чтения ключей:", err)
+	}
+
+	for _, k := range keys {
+		wrapped, keyID, err := config.Keyring.Rewrap(k.blobKey, k.dataKey, k.keyID)
+		if err != nil {
+			log.Fatalf("Ошибка перешифрования ключа %s: %v", k.blobKey, err)
+		}
+		_, err = config.PostgresDB.Exec(`
+			UPDATE BlobKeys SET data_key = $1, master_key_id = $2
+			WHERE blob_key = $3 AND master_key_id = $4
+		`, wrapped, keyID, k.blobKey, k.keyID)
+		if err != nil {
+			log.Fatalf("Ошибка сохранения ключа %s: %v", k.blobKey, err)
+		}
+	}
+
+	fmt.Printf("✅ Ключей перешифровано: %d (мастер-ключ %s)\n", len(keys), currentID)
+}
@@ -15,6 +15,19 @@ func RegisterRoutes() http.Handler {
 	// auth
 	router.HandleFunc("/register", handlers.RegisterUser).Methods("POST")
 	router.HandleFunc("/login", handlers.LoginUser).Methods("POST")
+	router.HandleFunc("/api/uploads", handlers.TusOptions).Methods("OPTIONS")
+	router.HandleFunc("/api/uploads/{upload_id}", handlers.TusOptions).Methods("OPTIONS")
+
+	// public share links, without an account
+	// POST takes the password as a form field
+	router.HandleFunc("/s/{token}", handlers.OpenLink).Methods("GET", "POST")
+	router.HandleFunc("/s/{token}/download", handlers.DownloadLink).Methods("GET", "HEAD", "POST")
+	router.HandleFunc("/s/{token}/folders/{folder_id}", handlers.OpenLinkFolder).Methods("GET", "POST")
+	router.HandleFunc("/s/{token}/files/{file_id}", handlers.DownloadLinkFile).Methods("GET", "HEAD", "POST")
+
+	// file requests, upload only without an account
+	router.HandleFunc("/r/{token}", handlers.OpenFileRequest).Methods("GET")
+	router.HandleFunc("/r/{token}", handlers.UploadToFileRequest).Methods("POST")
 
 	// protect
 	protected := router.PathPrefix("/api").Subrouter()
@@ -22,10 +35,35 @@ func RegisterRoutes() http.Handler {
 
 	// files
 	protected.HandleFunc("/files/upload", handlers.UploadFile).Methods("POST")
-	protected.HandleFunc("/files/{file_id}", handlers.DownloadFile).Methods("GET")
+	protected.HandleFunc("/files/{file_id}", handlers.DownloadFile).Methods("GET", "HEAD")
 	protected.HandleFunc("/files/{file_id}", handlers.UpdateFile).Methods("PUT")
 	protected.HandleFunc("/files/{file_id}", handlers.DeleteFile).Methods("DELETE")
 	protected.HandleFunc("/files", handlers.GetUserFiles).Methods("GET")
+	protected.HandleFunc("/files/{file_id}/move", handlers.MoveFile).Methods("POST")
+	protected.HandleFunc("/files/{file_id}/copy", handlers.CopyFile).Methods("POST")
+	protected.HandleFunc("/quota", handlers.GetQuota).Methods("GET")
+
+	// folders, "root" lists the top level
+	protected.HandleFunc("/folders", handlers.CreateFolder).Methods("POST")
+	protected.HandleFunc("/folders/tree", handlers.GetFolderTree).Methods("GET")
+	protected.HandleFunc("/folders/{folder_id}", handlers.GetFolder).Methods("GET")
+	protected.HandleFunc("/folders/{folder_id}", handlers.RenameFolder).Methods("PUT")
+	protected.HandleFunc("/folders/{folder_id}", handlers.DeleteFolder).Methods("DELETE")
+	protected.HandleFunc("/folders/{folder_id}/move", handlers.MoveFolder).Methods("POST")
+	protected.HandleFunc("/folders/{folder_id}/copy", handlers.CopyFolder).Methods("POST")
+	protected.HandleFunc("/folders/{folder_id}/children", handlers.GetFolderChildren).Methods("GET")
+
+	// trash
+	protected.HandleFunc("/trash", handlers.GetTrash).Methods("GET")
+	protected.HandleFunc("/trash", handlers.EmptyTrash).Methods("DELETE")
+	protected.HandleFunc("/trash/{file_id}/restore", handlers.RestoreFile).Methods("POST")
+	protected.HandleFunc("/trash/{file_id}", handlers.PurgeTrashedFile).Methods("DELETE")
+
+	// resumable uploads (tus)
+	protected.HandleFunc("/uploads", handlers.TusCreateUpload).Methods("POST")
+	protected.HandleFunc("/uploads/{upload_id}", handlers.TusUploadStatus).Methods("HEAD")
+	protected.HandleFunc("/uploads/{upload_id}", handlers.TusPatchUpload).Methods("PATCH")
+	protected.HandleFunc("/uploads/{upload_id}", handlers.TusDeleteUpload).Methods("DELETE")
 
 	// roles
 	protected.HandleFunc("/roles", middleware.RequirePermission("manage_roles", handlers.CreateRole)).Methods("POST")
@@ -46,6 +84,15 @@ func RegisterRoutes() http.Handler {
 	protected.HandleFunc("/groups/{id}", middleware.RequirePermission("manage_groups", handlers.UpdateGroup)).Methods("PUT")
 	protected.HandleFunc("/groups/{id}", middleware.RequirePermission("manage_groups", handlers.DeleteGroup)).Methods("DELETE")
 
+	// reports, ?format=csv for CSV
+	protected.HandleFunc("/reports/usage/users", middleware.RequirePermission("view_reports", handlers.ReportUsageByUser)).Methods("GET")
+	protected.HandleFunc("/reports/usage/groups", middleware.RequirePermission("view_reports", handlers.ReportUsageByGroup)).Methods("GET")
+	protected.HandleFunc("/reports/usage/types", middleware.RequirePermission("view_reports", handlers.ReportUsageByType)).Methods("GET")
+	protected.HandleFunc("/reports/usage/age", middleware.RequirePermission("view_reports", handlers.ReportUsageByAge)).Methods("GET")
+	protected.HandleFunc("/reports/largest-files", middleware.RequirePermission("view_reports", handlers.ReportLargestFiles)).Methods("GET")
+	protected.HandleFunc("/reports/stale-files", middleware.RequirePermission("view_reports", handlers.ReportStaleFiles)).Methods("GET")
+	protected.HandleFunc("/reports/version-overhead", middleware.RequirePermission("view_reports", handlers.ReportVersionOverhead)).Methods("GET")
+
 	// users
 	protected.HandleFunc("/users", handlers.CreateUser).Methods("POST")
 	protected.HandleFunc("/users/{id}", handlers.GetUser).Methods("GET")
@@ -54,12 +101,37 @@ func RegisterRoutes() http.Handler {
 	protected.HandleFunc("/users/{id}", handlers.DeleteUser).Methods("DELETE")
 
 	// sharing
+	protected.HandleFunc("/access", handlers.GetAccessLevels).Methods("GET")
 	protected.HandleFunc("/files/{file_id}/share/user", handlers.ShareFileWithUser).Methods("POST")
 	protected.HandleFunc("/files/{file_id}/share/group", handlers.ShareFileWithGroup).Methods("POST")
 	protected.HandleFunc("/files/{file_id}/permissions", handlers.GetFilePermissions).Methods("GET")
+	protected.HandleFunc("/files/{file_id}/permissions/effective", handlers.GetEffectiveFilePermissions).Methods("GET")
 	protected.HandleFunc("/shared-files", handlers.GetSharedFiles).Methods("GET")
 	protected.HandleFunc("/files/{file_id}/share/user/{user_id}", handlers.RevokeUserAccess).Methods("DELETE")
 	protected.HandleFunc("/files/{file_id}/share/group/{group_id}", handlers.RevokeGroupAccess).Methods("DELETE")
+	protected.HandleFunc("/folders/{folder_id}/share/user", handlers.ShareFolderWithUser).Methods("POST")
+	protected.HandleFunc("/folders/{folder_id}/share/group", handlers.ShareFolderWithGroup).Methods("POST")
+	protected.HandleFunc("/folders/{folder_id}/permissions", handlers.GetFolderPermissions).Methods("GET")
+	protected.HandleFunc("/folders/{folder_id}/permissions/effective", handlers.GetEffectiveFolderPermissions).Methods("GET")
+	protected.HandleFunc("/folders/{folder_id}/share/user/{user_id}", handlers.RevokeFolderUserAccess).Methods("DELETE")
+	protected.HandleFunc("/folders/{folder_id}/share/group/{group_id}", handlers.RevokeFolderGroupAccess).Methods("DELETE")
+
+	// share links
+	protected.HandleFunc("/files/{file_id}/links", handlers.CreateFileLink).Methods("POST")
+	protected.HandleFunc("/files/{file_id}/links", handlers.GetFileLinks).Methods("GET")
+	protected.HandleFunc("/folders/{folder_id}/links", handlers.CreateFolderLink).Methods("POST")
+	protected.HandleFunc("/folders/{folder_id}/links", handlers.GetFolderLinks).Methods("GET")
+	protected.HandleFunc("/links/{link_id}", handlers.RevokeLink).Methods("DELETE")
+
+	// file requests
+	protected.HandleFunc("/folders/{folder_id}/file-requests", handlers.CreateFileRequest).Methods("POST")
+	protected.HandleFunc("/folders/{folder_id}/file-requests", handlers.GetFileRequests).Methods("GET")
+	protected.HandleFunc("/file-requests/{request_id}/uploads", handlers.GetFileRequestUploads).Methods("GET")
+	protected.HandleFunc("/file-requests/{request_id}", handlers.RevokeFileRequest).Methods("DELETE")
+
+	// notifications
+	protected.HandleFunc("/notifications", handlers.GetNotifications).Methods("GET")
+	protected.HandleFunc("/notifications/{notification_id}/read", handlers.MarkNotificationRead).Methods("POST")
 
 	// versions
 	protected.HandleFunc("/files/{file_id}/version", handlers.CreateFileVersion).Methods("POST")
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressedStore compresses blobs on top of another BlobStore. Whether a
+// blob is compressed is part of its key ("gz:" or "zst:" before the key of
+// the underlying store), so raw blobs and blobs written with another
+// algorithm stay readable when the setting changes. A compressed blob is the
+// compressed stream followed by the uncompressed size as 8 big-endian bytes.
+type CompressedStore struct {
+	store     BlobStore
+	algorithm string // "gzip", "zstd" or "" for no compression
+	minSize   int
+}
+
+var errCompressedTruncated = fmt.Errorf("%w: compressed blob is truncated", ErrCorrupt)
+
+const (
+	gzipPrefix = "gz:"
+	zstdPrefix = "zst:"
+	sizeSuffix = 8
+)
+
+func NewCompressedStore(store BlobStore, algorithm string, minSize int) (*CompressedStore, error) {
+	switch algorithm {
+	case "", "gzip", "zstd":
+	default:
+		return nil, errors.New("unknown compression algorithm: " + algorithm)
+	}
+	return &CompressedStore{store: store, algorithm: algorithm, minSize: minSize}, nil
+}
+
+func (s *CompressedStore) Put(ctx context.Context, name string, r io.Reader) (BlobInfo, error) {
+	if s.algorithm == "" || !compressible(name) {
+		return s.putRaw(ctx, name, r)
+	}
+
+	// small blobs are not worth compressing
+	head := make([]byte, s.minSize)
+	n, err := io.ReadFull(r, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return s.putRaw(ctx, name, bytes.NewReader(head[:n]))
+	} else if err != nil {
+		return BlobInfo{}, err
+	}
+
+	source := &countingReader{r: io.MultiReader(bytes.NewReader(head), r)}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(s.compress(pw, source))
+	}()
+
+	info, err := s.store.Put(ctx, name, pr)
+	// unblocks the writer if Put stopped reading early
+	pr.CloseWithError(io.ErrClosedPipe)
+	<-done
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	info.Key = s.prefix() + info.Key
+	if info.StoredSize == 0 {
+		info.StoredSize = info.Size
+	}
+	info.Size = source.n
+	return info, nil
+}
+
+func (s *CompressedStore) putRaw(ctx context.Context, name string, r io.Reader) (BlobInfo, error) {
+	info, err := s.store.Put(ctx, name, r)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if info.StoredSize == 0 {
+		info.StoredSize = info.Size
+	}
+	return info, nil
+}
+
+// compress writes the compressed source and the size suffix to w
+func (s *CompressedStore) compress(w io.Writer, source *countingReader) error {
+	var cw io.WriteCloser
+	if s.algorithm == "zstd" {
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		cw = enc
+	} else {
+		cw = gzip.NewWriter(w)
+	}
+
+	if _, err := io.Copy(cw, source); err != nil {
+		cw.Close()
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+
+	var size [sizeSuffix]byte
+	binary.BigEndian.PutUint64(size[:], uint64(source.n))
+	_, err := w.Write(size[:])
+	return err
+}
+
+func (s *CompressedStore) prefix() string {
+	if s.algorithm == "zstd" {
+		return zstdPrefix
+	}
+	return gzipPrefix
+}
+
+func (s *CompressedStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+// GetRange of a compressed blob decompresses from the start and skips offset bytes
+func (s *CompressedStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	algorithm, inner := splitKey(key)
+	if algorithm == "" {
+		return s.store.GetRange(ctx, inner, offset, length)
+	}
+
+	info, err := s.store.Stat(ctx, inner)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size < sizeSuffix {
+		return nil, errCompressedTruncated
+	}
+	rc, err := s.store.GetRange(ctx, inner, 0, info.Size-sizeSuffix)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := decompress(algorithm, rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, dr, offset); err != nil && err != io.EOF {
+		dr.Close()
+		return nil, err
+	}
+	return limitRange(dr, length), nil
+}
+
+func (s *CompressedStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	algorithm, inner := splitKey(key)
+	info, err := s.store.Stat(ctx, inner)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	info.Key = key
+	if info.StoredSize == 0 {
+		info.StoredSize = info.Size
+	}
+	if algorithm == "" {
+		return info, nil
+	}
+
+	if info.Size < sizeSuffix {
+		return BlobInfo{}, errCompressedTruncated
+	}
+	rc, err := s.store.GetRange(ctx, inner, info.Size-sizeSuffix, sizeSuffix)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer rc.Close()
+
+	var size [sizeSuffix]byte
+	if _, err := io.ReadFull(rc, size[:]); err != nil {
+		return BlobInfo{}, err
+	}
+	info.Size = int64(binary.BigEndian.Uint64(size[:]))
+	return info, nil
+}
+
+func (s *CompressedStore) Delete(ctx context.Context, key string) error {
+	_, inner := splitKey(key)
+	return s.store.Delete(ctx, inner)
+}
+
+func (s *CompressedStore) Unwrap() BlobStore {
+	return s.store
+}
+
+func splitKey(key string) (algorithm, inner string) {
+	if strings.HasPrefix(key, gzipPrefix) {
+		return "gzip", strings.TrimPrefix(key, gzipPrefix)
+	}
+	if strings.HasPrefix(key, zstdPrefix) {
+		return "zstd", strings.TrimPrefix(key, zstdPrefix)
+	}
+	return "", key
+}
+
+// decompressReader closes both the decoder and the stored blob
+type decompressReader struct {
+	io.Reader
+	close  func()
+	blob   io.Closer
+	source *sourceReader
+}
+
+func (d *decompressReader) Read(p []byte) (int, error) {
+	n, err := d.Reader.Read(p)
+	return n, d.source.corrupt(err)
+}
+
+func (d *decompressReader) Close() error {
+	d.close()
+	return d.blob.Close()
+}
+
+func decompress(algorithm string, rc io.ReadCloser) (io.ReadCloser, error) {
+	source := &sourceReader{r: rc}
+	if algorithm == "zstd" {
+		dec, err := zstd.NewReader(source, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &decompressReader{Reader: dec, close: dec.Close, blob: rc, source: source}, nil
+	}
+
+	zr, err := gzip.NewReader(source)
+	if err != nil {
+		return nil, source.corrupt(err)
+	}
+	return &decompressReader{Reader: zr, close: func() { zr.Close() }, blob: rc, source: source}, nil
+}
+
+// text formats missing from the builtin mime table when the system has none
+var textExtensions = map[string]bool{
+	".txt": true, ".csv": true, ".tsv": true, ".md": true, ".log": true,
+	".yaml": true, ".yml": true, ".ini": true, ".sql": true,
+}
+
+// compressible tells by the file extension whether the content is likely
+// to shrink; archives, media and unknown types are stored as they are
+func compressible(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if textExtensions[ext] {
+		return true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-javascript", "application/rtf", "application/x-sh",
+		"application/sql", "application/x-yaml", "application/yaml",
+		"application/msword", "application/vnd.ms-excel",
+		"application/vnd.ms-powerpoint", "image/svg+xml", "image/bmp",
+		"image/x-ms-bmp", "image/tiff":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+xml") || strings.HasSuffix(mediaType, "+json")
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// overwrite replaces the stored bytes of a blob in a local store
+func overwrite(t *testing.T, store *LocalStore, key string, edit func([]byte) []byte) {
+	t.Helper()
+	path, err := store.path(BaseKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, edit(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func textData(lines int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&b, "line %d of a very compressible log file\n", i)
+	}
+	return b.Bytes()
+}
+
+func TestCompressedRoundTrip(t *testing.T) {
+	data := textData(5000)
+	for _, tc := range []struct{ algorithm, prefix string }{
+		{"gzip", gzipPrefix},
+		{"zstd", zstdPrefix},
+	} {
+		t.Run(tc.algorithm, func(t *testing.T) {
+			store, err := NewCompressedStore(newLocal(t), tc.algorithm, 1024)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+
+			info, err := store.Put(ctx, "server.log", bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(info.Key, tc.prefix) {
+				t.Errorf("key %q has no %q prefix", info.Key, tc.prefix)
+			}
+			if info.Size != int64(len(data)) || info.StoredSize >= info.Size {
+				t.Errorf("Put size %d stored %d, want %d and less stored", info.Size, info.StoredSize, len(data))
+			}
+
+			rc, err := store.Get(ctx, info.Key)
+			if got := readAll(t, rc, err); !bytes.Equal(got, data) {
+				t.Error("Get returned other data")
+			}
+			for _, r := range [][2]int64{{0, 10}, {1000, 5000}, {int64(len(data)) - 7, -1}, {int64(len(data)) + 5, -1}} {
+				rc, err := store.GetRange(ctx, info.Key, r[0], r[1])
+				got := readAll(t, rc, err)
+				end := int64(len(data))
+				if r[1] >= 0 && r[0]+r[1] < end {
+					end = r[0] + r[1]
+				}
+				want := []byte{}
+				if r[0] < end {
+					want = data[r[0]:end]
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("GetRange(%d, %d) returned %d bytes, want %d", r[0], r[1], len(got), len(want))
+				}
+			}
+
+			stat, err := store.Stat(ctx, info.Key)
+			if err != nil || stat.Size != int64(len(data)) || stat.StoredSize != info.StoredSize {
+				t.Errorf("Stat = %+v, %v", stat, err)
+			}
+		})
+	}
+}
+
+func TestCompressedStoresSmallAndMediaRaw(t *testing.T) {
+	store, _ := NewCompressedStore(newLocal(t), "zstd", 1024)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"short.txt", []byte("too short to compress")},
+		{"photo.jpg", textData(100)},
+	} {
+		info, err := store.Put(ctx, tc.name, bytes.NewReader(tc.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if algorithm, _ := splitKey(info.Key); algorithm != "" {
+			t.Errorf("%s stored with %s", tc.name, algorithm)
+		}
+		rc, err := store.Get(ctx, info.Key)
+		if got := readAll(t, rc, err); !bytes.Equal(got, tc.data) {
+			t.Errorf("%s read back %q", tc.name, got)
+		}
+	}
+}
+
+func TestCompressedReadableWithCompressionOff(t *testing.T) {
+	local := newLocal(t)
+	gzipped, _ := NewCompressedStore(local, "gzip", 0)
+	info, err := gzipped.Put(context.Background(), "a.txt", bytes.NewReader(textData(10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	off, _ := NewCompressedStore(local, "", 0)
+	rc, err := off.Get(context.Background(), info.Key)
+	if got := readAll(t, rc, err); !bytes.Equal(got, textData(10)) {
+		t.Error("a gzip blob is not readable with compression off")
+	}
+}
+
+func TestCompressedDamage(t *testing.T) {
+	local := newLocal(t)
+	store, _ := NewCompressedStore(local, "gzip", 0)
+	ctx := context.Background()
+
+	info, err := store.Put(ctx, "a.txt", bytes.NewReader(textData(1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	overwrite(t, local, info.Key, func(b []byte) []byte {
+		b[len(b)/2] ^= 0xff
+		return b
+	})
+	rc, err := store.Get(ctx, info.Key)
+	if err == nil {
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+	}
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("reading a damaged blob: %v, want ErrCorrupt", err)
+	}
+
+	overwrite(t, local, info.Key, func(b []byte) []byte { return b[:3] })
+	if _, err := store.Stat(ctx, info.Key); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Stat of a truncated blob: %v, want ErrCorrupt", err)
+	}
+}
@@ -0,0 +1,318 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EncryptedStore encrypts blobs on top of another BlobStore. Every blob has
+// its own random data key; the data key is wrapped with the master key and
+// kept in a KeyStore, so rotating the master key only re-wraps data keys.
+//
+// Content is split into chunks of encChunkSize bytes, each sealed with
+// AES-GCM using the chunk number as nonce, so a range read only decrypts
+// from the chunk it starts in. The last chunk is always shorter than a full
+// one (possibly empty), which makes a blob cut at a chunk border fail to read.
+// Encrypted blobs have the "enc:" key prefix, blobs without it are read as is.
+type EncryptedStore struct {
+	store BlobStore
+	keys  KeyStore
+	ring  *Keyring
+}
+
+// KeyStore keeps wrapped data keys by the key of the encrypted blob
+type KeyStore interface {
+	SaveKey(ctx context.Context, blobKey, wrapped, masterKeyID string) error
+	LoadKey(ctx context.Context, blobKey string) (wrapped, masterKeyID string, err error)
+	DeleteKey(ctx context.Context, blobKey string) error
+}
+
+const (
+	encPrefix     = "enc:"
+	encChunkSize  = 64 << 10
+	encOverhead   = 16 // GCM tag
+	encStoredSize = encChunkSize + encOverhead
+)
+
+var (
+	errBadChunk   = fmt.Errorf("encrypted %w", ErrCorrupt)
+	errBadDataKey = fmt.Errorf("%w: wrapped data key does not open", ErrCorrupt)
+)
+
+func NewEncryptedStore(store BlobStore, keys KeyStore, ring *Keyring) *EncryptedStore {
+	return &EncryptedStore{store: store, keys: keys, ring: ring}
+}
+
+func (s *EncryptedStore) Put(ctx context.Context, name string, r io.Reader) (BlobInfo, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return BlobInfo{}, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	source := &countingReader{r: r}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(encryptChunks(pw, source, aead))
+	}()
+
+	info, err := s.store.Put(ctx, name, pr)
+	// unblocks the writer if Put stopped reading early
+	pr.CloseWithError(io.ErrClosedPipe)
+	<-done
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	key := encPrefix + info.Key
+	wrapped, keyID, err := s.ring.Wrap(key, dataKey)
+	if err == nil {
+		err = s.keys.SaveKey(ctx, key, wrapped, keyID)
+	}
+	if err != nil {
+		s.store.Delete(ctx, info.Key)
+		return BlobInfo{}, err
+	}
+
+	info.Key = key
+	info.StoredSize = info.Size
+	info.Size = source.n
+	return info, nil
+}
+
+func encryptChunks(w io.Writer, r io.Reader, aead cipher.AEAD) error {
+	buf := make([]byte, encChunkSize, encStoredSize)
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		sealed := aead.Seal(buf[:0], chunkNonce(index), buf[:n], nil)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if n < encChunkSize {
+			return nil
+		}
+	}
+}
+
+func (s *EncryptedStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+func (s *EncryptedStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	inner, ok := strings.CutPrefix(key, encPrefix)
+	if !ok {
+		return s.store.GetRange(ctx, key, offset, length)
+	}
+
+	aead, err := s.dataKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	index := offset / encChunkSize
+	rc, err := s.store.GetRange(ctx, inner, index*encStoredSize, -1)
+	if err != nil {
+		return nil, err
+	}
+	dr := &decryptReader{rc: rc, source: &sourceReader{r: rc}, aead: aead, index: uint64(index), buf: make([]byte, encStoredSize)}
+	if _, err := io.CopyN(io.Discard, dr, offset%encChunkSize); err != nil && err != io.EOF {
+		dr.Close()
+		return nil, err
+	}
+	return limitRange(dr, length), nil
+}
+
+func (s *EncryptedStore) dataKey(ctx context.Context, key string) (cipher.AEAD, error) {
+	wrapped, keyID, err := s.keys.LoadKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := s.ring.Unwrap(key, wrapped, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(dataKey)
+}
+
+func (s *EncryptedStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	inner, ok := strings.CutPrefix(key, encPrefix)
+	info, err := s.store.Stat(ctx, inner)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	info.Key = key
+	info.StoredSize = info.Size
+	if ok {
+		// fail before a download starts if the data key cannot be unwrapped
+		if _, err := s.dataKey(ctx, key); err != nil {
+			return BlobInfo{}, err
+		}
+		chunks := info.Size/encStoredSize + 1
+		info.Size -= chunks * encOverhead
+		if info.Size < 0 {
+			return BlobInfo{}, errBadChunk
+		}
+	}
+	return info, nil
+}
+
+func (s *EncryptedStore) Delete(ctx context.Context, key string) error {
+	inner, ok := strings.CutPrefix(key, encPrefix)
+	if err := s.store.Delete(ctx, inner); err != nil {
+		return err
+	}
+	if ok {
+		return s.keys.DeleteKey(ctx, key)
+	}
+	return nil
+}
+
+func (s *EncryptedStore) Unwrap() BlobStore {
+	return s.store
+}
+
+// decryptReader opens chunks one by one starting from chunk number index
+type decryptReader struct {
+	rc     io.ReadCloser
+	source *sourceReader
+	aead   cipher.AEAD
+	index  uint64
+	buf    []byte
+	plain  []byte
+	last   bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(d.source, d.buf)
+		if d.source.err != nil {
+			// a failed read, not a cut off blob
+			return 0, d.source.err
+		} else if err == io.EOF {
+			// the previous chunk was full, so the end was cut off
+			return 0, errBadChunk
+		}
+
+		plain, err := d.aead.Open(d.buf[:0], chunkNonce(d.index), d.buf[:n], nil)
+		if err != nil {
+			return 0, errBadChunk
+		}
+		d.plain = plain
+		d.last = n < encStoredSize
+		d.index++
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.rc.Close()
+}
+
+func chunkNonce(index uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], index)
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Keyring holds the current master key, used for wrapping, and previous
+// ones that can still unwrap data keys until they are rotated
+type Keyring struct {
+	currentID string
+	keys      map[string]cipher.AEAD
+}
+
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	ring := &Keyring{keys: map[string]cipher.AEAD{}}
+	for i, key := range append([][]byte{current}, previous...) {
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		id := MasterKeyID(key)
+		ring.keys[id] = aead
+		if i == 0 {
+			ring.currentID = id
+		}
+	}
+	return ring, nil
+}
+
+// MasterKeyID names a master key without revealing it
+func MasterKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (k *Keyring) CurrentID() string {
+	return k.currentID
+}
+
+// Wrap seals a data key with the current master key; the blob key is bound
+// as additional data so a wrapped key cannot be moved to another blob
+func (k *Keyring) Wrap(blobKey string, dataKey []byte) (wrapped, keyID string, err error) {
+	aead := k.keys[k.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	sealed := aead.Seal(nonce, nonce, dataKey, []byte(blobKey))
+	return base64.StdEncoding.EncodeToString(sealed), k.currentID, nil
+}
+
+func (k *Keyring) Unwrap(blobKey, wrapped, keyID string) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, errors.New("master key " + keyID + " is not configured")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, errBadDataKey
+	}
+	nonce := sealed[:aead.NonceSize()]
+	dataKey, err := aead.Open(nil, nonce, sealed[aead.NonceSize():], []byte(blobKey))
+	if err != nil {
+		return nil, errBadDataKey
+	}
+	return dataKey, nil
+}
+
+// Rewrap moves a data key to the current master key
+func (k *Keyring) Rewrap(blobKey, wrapped, keyID string) (string, string, error) {
+	dataKey, err := k.Unwrap(blobKey, wrapped, keyID)
+	if err != nil {
+		return "", "", err
+	}
+	return k.Wrap(blobKey, dataKey)
+}
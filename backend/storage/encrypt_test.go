@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// memKeys keeps wrapped data keys in memory
+type memKeys map[string][2]string
+
+func (m memKeys) SaveKey(ctx context.Context, blobKey, wrapped, masterKeyID string) error {
+	m[blobKey] = [2]string{wrapped, masterKeyID}
+	return nil
+}
+
+func (m memKeys) LoadKey(ctx context.Context, blobKey string) (string, string, error) {
+	key, ok := m[blobKey]
+	if !ok {
+		return "", "", ErrNotFound
+	}
+	return key[0], key[1], nil
+}
+
+func (m memKeys) DeleteKey(ctx context.Context, blobKey string) error {
+	delete(m, blobKey)
+	return nil
+}
+
+func newEncrypted(t *testing.T) (*EncryptedStore, *LocalStore) {
+	t.Helper()
+	masterKey := make([]byte, 32)
+	rand.Read(masterKey)
+	ring, err := NewKeyring(masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := newLocal(t)
+	return NewEncryptedStore(local, memKeys{}, ring), local
+}
+
+func randomData(n int) []byte {
+	data := make([]byte, n)
+	rand.Read(data)
+	return data
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	store, local := newEncrypted(t)
+	ctx := context.Background()
+
+	for _, size := range []int{0, 1, encChunkSize - 1, encChunkSize, encChunkSize + 1, 2 * encChunkSize, 3*encChunkSize + 100} {
+		data := randomData(size)
+		info, err := store.Put(ctx, "secret.bin", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size != int64(size) {
+			t.Errorf("size %d: Put size = %d", size, info.Size)
+		}
+
+		rc, err := local.Get(ctx, BaseKey(info.Key))
+		stored := readAll(t, rc, err)
+		if size > 16 && bytes.Contains(stored, data[:16]) {
+			t.Errorf("size %d: stored blob contains the plaintext", size)
+		}
+
+		rc, err = store.Get(ctx, info.Key)
+		if got := readAll(t, rc, err); !bytes.Equal(got, data) {
+			t.Errorf("size %d: Get returned other data", size)
+		}
+		stat, err := store.Stat(ctx, info.Key)
+		if err != nil || stat.Size != int64(size) || stat.StoredSize != int64(len(stored)) {
+			t.Errorf("size %d: Stat = %+v, %v", size, stat, err)
+		}
+	}
+}
+
+func TestEncryptedGetRangeAcrossChunks(t *testing.T) {
+	store, _ := newEncrypted(t)
+	ctx := context.Background()
+
+	data := randomData(3*encChunkSize + 100)
+	info, err := store.Put(ctx, "secret.bin", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := int64(len(data))
+	for _, r := range [][2]int64{
+		{0, 10},
+		{encChunkSize - 1, 2},                // last byte of a chunk and first of the next
+		{encChunkSize, encChunkSize},         // exactly one chunk
+		{encChunkSize - 5, encChunkSize},     // ends inside the next chunk
+		{encChunkSize + 7, 2 * encChunkSize}, // spans three chunks
+		{2*encChunkSize - 10, -1},            // to the end from inside a chunk
+		{3 * encChunkSize, -1},               // the short last chunk
+		{size - 1, 10},
+		{size, -1},
+		{size + 100, 10},
+		{5, 0},
+	} {
+		offset, length := r[0], r[1]
+		rc, err := store.GetRange(ctx, info.Key, offset, length)
+		got := readAll(t, rc, err)
+
+		end := size
+		if length >= 0 && offset+length < end {
+			end = offset + length
+		}
+		want := []byte{}
+		if offset < end {
+			want = data[offset:end]
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("GetRange(%d, %d) returned %d bytes, want %d", offset, length, len(got), len(want))
+		}
+	}
+}
+
+func TestEncryptedDamage(t *testing.T) {
+	store, local := newEncrypted(t)
+	ctx := context.Background()
+
+	data := randomData(3 * encChunkSize)
+	info, err := store.Put(ctx, "secret.bin", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a flipped byte in the second chunk leaves the first one readable
+	overwrite(t, local, info.Key, func(b []byte) []byte {
+		b[encStoredSize+10] ^= 0xff
+		return b
+	})
+	rc, err := store.GetRange(ctx, info.Key, 0, encChunkSize)
+	if got := readAll(t, rc, err); !bytes.Equal(got, data[:encChunkSize]) {
+		t.Error("first chunk is not readable")
+	}
+	if _, err := readErr(store.GetRange(ctx, info.Key, encChunkSize, 10)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("reading the damaged chunk: %v, want ErrCorrupt", err)
+	}
+
+	// the blob ends after a full chunk, so the last one was cut off
+	overwrite(t, local, info.Key, func(b []byte) []byte { return b[:3*encStoredSize] })
+	if _, err := readErr(store.Get(ctx, info.Key)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("reading a cut off blob: %v, want ErrCorrupt", err)
+	}
+}
+
+// readErr reads a blob to the end and returns the first error
+func readErr(rc io.ReadCloser, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(io.Discard, rc)
+}
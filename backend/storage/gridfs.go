@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+)
+
+// GridFSStore keeps blobs in the MongoDB fs.files/fs.chunks collections.
+// Keys are hex ObjectIDs.
+type GridFSStore struct {
+	db *mongo.Database
+}
+
+func NewGridFSStore(db *mongo.Database) *GridFSStore {
+	return &GridFSStore{db: db}
+}
+
+// bucket is not safe for concurrent use, so every call opens its own
+func (s *GridFSStore) bucket() (*gridfs.Bucket, error) {
+	return gridfs.NewBucket(s.db)
+}
+
+func (s *GridFSStore) Put(ctx context.Context, name string, r io.Reader) (BlobInfo, error) {
+	bucket, err := s.bucket()
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	uploadStream, err := bucket.OpenUploadStream(name)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	_, err = io.Copy(uploadStream, r)
+	if err != nil {
+		uploadStream.Abort()
+		return BlobInfo{}, err
+	}
+	if err := uploadStream.Close(); err != nil {
+		return BlobInfo{}, err
+	}
+
+	return s.Stat(ctx, uploadStream.FileID.(primitive.ObjectID).Hex())
+}
+
+func (s *GridFSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+func (s *GridFSStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	bucket, err := s.bucket()
+	if err != nil {
+		return nil, err
+	}
+
+	downloadStream, err := bucket.OpenDownloadStream(id)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if _, err := downloadStream.Skip(offset); err != nil {
+			downloadStream.Close()
+			return nil, err
+		}
+	}
+
+	return limitRange(downloadStream, length), nil
+}
+
+func (s *GridFSStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return BlobInfo{}, ErrNotFound
+	}
+
+	var file gridfs.File
+	err = s.db.Collection("fs.files").FindOne(ctx, bson.M{"_id": id}).Decode(&file)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return BlobInfo{}, ErrNotFound
+	} else if err != nil {
+		return BlobInfo{}, err
+	}
+
+	return BlobInfo{
+		Key:        key,
+		Name:       file.Name,
+		Size:       file.Length,
+		UploadDate: file.UploadDate,
+	}, nil
+}
+
+func (s *GridFSStore) Delete(ctx context.Context, key string) error {
+	id, err := primitive.ObjectIDFromHex(key)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	bucket, err := s.bucket()
+	if err != nil {
+		return err
+	}
+
+	err = bucket.DeleteContext(ctx, id)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *GridFSStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	cursor, err := s.db.Collection("fs.files").Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var file gridfs.File
+		if err := cursor.Decode(&file); err != nil {
+			return err
+		}
+		info := BlobInfo{Key: gridfsKey(file.ID), Name: file.Name, Size: file.Length, UploadDate: file.UploadDate}
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Check compares fs.chunks with fs.files: files with missing or extra
+// chunks, and chunks left behind by a deleted file, which are removed on repair
+func (s *GridFSStore) Check(ctx context.Context, repair bool) ([]Problem, error) {
+	cursor, err := s.db.Collection("fs.chunks").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$files_id", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		ID    interface{} `bson:"_id"`
+		Count int64       `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	chunks := map[string]int64{}
+	chunkIDs := map[string]interface{}{}
+	for _, g := range groups {
+		key := gridfsKey(g.ID)
+		chunks[key] = g.Count
+		chunkIDs[key] = g.ID
+	}
+
+	var problems []Problem
+	files := map[string]bool{}
+	cursor, err = s.db.Collection("fs.files").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var file gridfs.File
+		if err := cursor.Decode(&file); err != nil {
+			return nil, err
+		}
+		key := gridfsKey(file.ID)
+		files[key] = true
+
+		var expected int64
+		if file.ChunkSize > 0 {
+			expected = (file.Length + int64(file.ChunkSize) - 1) / int64(file.ChunkSize)
+		}
+		if chunks[key] != expected {
+			problems = append(problems, Problem{
+				Kind:   "chunk_count_mismatch",
+				Key:    key,
+				Detail: fmt.Sprintf("expected %d chunks, found %d", expected, chunks[key]),
+			})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	for key, count := range chunks {
+		if files[key] {
+			continue
+		}
+		problem := Problem{Kind: "orphaned_chunks", Key: key, Detail: fmt.Sprintf("%d chunks without fs.files entry", count)}
+		if repair {
+			_, err := s.db.Collection("fs.chunks").DeleteMany(ctx, bson.M{"files_id": chunkIDs[key]})
+			if err != nil {
+				return nil, err
+			}
+			problem.Repaired = true
+		}
+		problems = append(problems, problem)
+	}
+	return problems, nil
+}
+
+func gridfsKey(id interface{}) string {
+	if oid, ok := id.(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+	return fmt.Sprint(id)
+}
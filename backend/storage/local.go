@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// LocalStore keeps blobs as files under root, sharded by the first
+// characters of the key: root/ab/cd/abcd...
+type LocalStore struct {
+	root string
+}
+
+func NewLocalStore(root string) (*LocalStore, error) {
+	if err := os.MkdirAll(filepath.Join(root, "tmp"), 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{root: root}, nil
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	if len(key) < 5 {
+		return "", ErrNotFound
+	}
+	if _, err := hex.DecodeString(key); err != nil {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.root, key[0:2], key[2:4], key), nil
+}
+
+func newKey() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Put writes to a temp file first and renames it into place, so a blob
+// is either complete or absent
+func (s *LocalStore) Put(ctx context.Context, name string, r io.Reader) (BlobInfo, error) {
+	key, err := newKey()
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	path, _ := s.path(key)
+
+	tmp, err := os.CreateTemp(filepath.Join(s.root, "tmp"), key+"-*")
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return BlobInfo{}, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return BlobInfo{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return BlobInfo{}, err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return BlobInfo{}, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return BlobInfo{}, err
+	}
+	if err := syncDir(dir); err != nil {
+		return BlobInfo{}, err
+	}
+
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	info.Name = name
+	return info, nil
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+func (s *LocalStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return limitRange(file, length), nil
+}
+
+func (s *LocalStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return BlobInfo{}, ErrNotFound
+	} else if err != nil {
+		return BlobInfo{}, err
+	}
+
+	return BlobInfo{
+		Key:        key,
+		Size:       fi.Size(),
+		UploadDate: fi.ModTime(),
+	}, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *LocalStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	return filepath.WalkDir(s.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path == filepath.Join(s.root, "tmp") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// anything not at the path of its own key is not a blob
+		key := entry.Name()
+		if expected, err := s.path(key); err != nil || expected != path {
+			return nil
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return fn(BlobInfo{Key: key, Size: fi.Size(), UploadDate: fi.ModTime()})
+	})
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
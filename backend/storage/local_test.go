@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newLocal(t *testing.T) *LocalStore {
+	t.Helper()
+	store, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestLocalRoundTrip(t *testing.T) {
+	store := newLocal(t)
+	ctx := context.Background()
+
+	info, err := store.Put(ctx, "notes.txt", strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 11 || info.Name != "notes.txt" {
+		t.Errorf("Put = %+v", info)
+	}
+
+	rc, err := store.Get(ctx, info.Key)
+	if got := readAll(t, rc, err); string(got) != "hello world" {
+		t.Errorf("Get = %q", got)
+	}
+	for _, tc := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 5, "hello"},
+		{6, -1, "world"},
+		{4, 3, "o w"},
+		{10, 10, "d"},
+		{20, -1, ""},
+		{3, 0, ""},
+	} {
+		rc, err := store.GetRange(ctx, info.Key, tc.offset, tc.length)
+		if got := readAll(t, rc, err); string(got) != tc.want {
+			t.Errorf("GetRange(%d, %d) = %q, want %q", tc.offset, tc.length, got, tc.want)
+		}
+	}
+
+	stat, err := store.Stat(ctx, info.Key)
+	if err != nil || stat.Size != 11 {
+		t.Errorf("Stat = %+v, %v", stat, err)
+	}
+
+	var listed []string
+	if err := store.List(ctx, func(b BlobInfo) error {
+		listed = append(listed, b.Key)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0] != info.Key {
+		t.Errorf("List = %v, want [%s]", listed, info.Key)
+	}
+
+	if err := store.Delete(ctx, info.Key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, info.Key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, info.Key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete: %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalListSkipsForeignFiles(t *testing.T) {
+	store := newLocal(t)
+	os.WriteFile(filepath.Join(store.root, "tmp", "abcdef-123"), []byte("partial"), 0o644)
+	os.WriteFile(filepath.Join(store.root, "README"), []byte("not a blob"), 0o644)
+
+	err := store.List(context.Background(), func(b BlobInfo) error {
+		t.Errorf("List returned %q", b.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalRejectsKeysOutsideRoot(t *testing.T) {
+	store := newLocal(t)
+	for _, key := range []string{"../../etc/passwd", "ab", "abcd/../../x", ""} {
+		if _, err := store.Get(context.Background(), key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q): %v, want ErrNotFound", key, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BlobReader is an io.ReadSeeker over a stored blob, so it can be given to
+// http.ServeContent. The blob is opened lazily with GetRange at the current
+// offset, and reopened after every seek.
+type BlobReader struct {
+	ctx    context.Context
+	store  BlobStore
+	key    string
+	size   int64
+	offset int64
+	rc     io.ReadCloser
+}
+
+func NewBlobReader(ctx context.Context, store BlobStore, key string, size int64) *BlobReader {
+	return &BlobReader{ctx: ctx, store: store, key: key, size: size}
+}
+
+func (b *BlobReader) Read(p []byte) (int, error) {
+	if b.offset >= b.size {
+		return 0, io.EOF
+	}
+	if b.rc == nil {
+		rc, err := b.store.GetRange(b.ctx, b.key, b.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		b.rc = rc
+	}
+
+	n, err := b.rc.Read(p)
+	b.offset += int64(n)
+	return n, err
+}
+
+func (b *BlobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative seek offset")
+	}
+
+	if offset != b.offset {
+		b.Close()
+		b.offset = offset
+	}
+	return b.offset, nil
+}
+
+func (b *BlobReader) Close() error {
+	if b.rc == nil {
+		return nil
+	}
+	err := b.rc.Close()
+	b.rc = nil
+	return err
+}
+
+// sourceReader remembers read errors of the stored blob, so the wrapping
+// stores can tell a failed read from damaged data
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// corrupt marks a decoding error as damage unless reading the blob failed
+func (s *sourceReader) corrupt(err error) error {
+	if err == nil || err == io.EOF || s.err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrCorrupt, err)
+}
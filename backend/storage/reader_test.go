@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// failingStore fails reads of its blobs halfway, as a dropped connection does
+type failingStore struct {
+	BlobStore
+}
+
+func (s failingStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := s.BlobStore.GetRange(ctx, key, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	return limitReadCloser{Reader: io.MultiReader(io.LimitReader(rc, 100), errReader{}), Closer: rc}, nil
+}
+
+func TestReadErrorsAreNotDamage(t *testing.T) {
+	store, local := newEncrypted(t)
+	compressed, _ := NewCompressedStore(local, "gzip", 0)
+	ctx := context.Background()
+
+	encrypted, err := store.Put(ctx, "secret.bin", bytes.NewReader(randomData(2*encChunkSize)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipped, err := compressed.Put(ctx, "a.txt", bytes.NewReader(textData(1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.store = failingStore{local}
+	compressed.store = failingStore{local}
+	for key, s := range map[string]BlobStore{encrypted.Key: store, gzipped.Key: compressed} {
+		_, err := readErr(s.Get(ctx, key))
+		if err == nil || errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: %v, want the read error", key, err)
+		}
+	}
+}
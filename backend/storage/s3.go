@@ -0,0 +1,519 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minimum part size allowed by S3 for every part except the last
+const minPartSize = 5 << 20
+
+type S3Config struct {
+	Endpoint  string // e.g. http://minio:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PartSize  int64 // objects larger than this are sent with multipart upload
+}
+
+// S3Store keeps blobs in an S3-compatible bucket using path-style
+// requests signed with AWS Signature Version 4
+type S3Store struct {
+	cfg    S3Config
+	client *http.Client
+	parts  sync.Pool // *[]byte of PartSize, reused between uploads
+}
+
+func NewS3Store(ctx context.Context, cfg S3Config) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3: endpoint and bucket are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PartSize < minPartSize {
+		cfg.PartSize = 16 << 20
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+
+	s := &S3Store{cfg: cfg, client: &http.Client{}}
+	s.parts.New = func() interface{} {
+		buf := make([]byte, cfg.PartSize)
+		return &buf
+	}
+	if err := s.ensureBucket(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *S3Store) ensureBucket(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("s3: bucket %s: %s", s.cfg.Bucket, resp.Status)
+	}
+
+	var body []byte
+	if s.cfg.Region != "us-east-1" {
+		body = []byte(`<CreateBucketConfiguration><LocationConstraint>` + s.cfg.Region + `</LocationConstraint></CreateBucketConfiguration>`)
+	}
+	_, err = s.call(ctx, http.MethodPut, "", nil, nil, body)
+	return err
+}
+
+func (s *S3Store) Put(ctx context.Context, name string, r io.Reader) (BlobInfo, error) {
+	key, err := newKey()
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	header := http.Header{}
+	header.Set("X-Amz-Meta-Filename", url.PathEscape(name))
+
+	buf := s.parts.Get().(*[]byte)
+	var sent <-chan struct{}
+	defer func() { s.release(buf, sent) }()
+
+	// small objects go in a single request
+	part := *buf
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if _, sent, err = s.send(ctx, http.MethodPut, key, nil, header, part[:n]); err != nil {
+			return BlobInfo{}, err
+		}
+		return BlobInfo{Key: key, Name: name, Size: int64(n), UploadDate: time.Now()}, nil
+	} else if err != nil {
+		return BlobInfo{}, err
+	}
+
+	size, err := s.putMultipart(ctx, key, header, r, part, &sent)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Key: key, Name: name, Size: size, UploadDate: time.Now()}, nil
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// release returns buf to the pool once the transport is done with the last
+// request body sent from it
+func (s *S3Store) release(buf *[]byte, sent <-chan struct{}) {
+	if sent == nil {
+		s.parts.Put(buf)
+		return
+	}
+	go func() {
+		<-sent
+		s.parts.Put(buf)
+	}()
+}
+
+// putMultipart uploads first (a full part already read) and the rest of r
+// one part at a time, so memory use stays at a single part. sent is set as
+// in send for the last part.
+func (s *S3Store) putMultipart(ctx context.Context, key string, header http.Header, r io.Reader, first []byte, sent *<-chan struct{}) (int64, error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return 0, err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+	uploadID := initiated.UploadID
+
+	abort := func() {
+		s.call(context.Background(), http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	}
+
+	var parts []completedPart
+	var size int64
+	buf := first
+	n := len(first)
+	for partNumber := 1; n > 0; partNumber++ {
+		query := url.Values{
+			"partNumber": {strconv.Itoa(partNumber)},
+			"uploadId":   {uploadID},
+		}
+		resp, partSent, err := s.send(ctx, http.MethodPut, key, query, nil, buf[:n])
+		*sent = partSent
+		if err != nil {
+			abort()
+			return 0, err
+		}
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+		size += int64(n)
+
+		// the next part goes in the same buffer
+		select {
+		case <-partSent:
+		case <-ctx.Done():
+			abort()
+			return 0, ctx.Err()
+		}
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			abort()
+			return 0, err
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		abort()
+		return 0, err
+	}
+	resp, err = s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		abort()
+		return 0, err
+	}
+	if err := checkResponse(resp); err != nil {
+		abort()
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// CompleteMultipartUpload may fail after sending 200 OK
+	var s3Err s3Error
+	if xml.NewDecoder(resp.Body).Decode(&s3Err) == nil && s3Err.Code != "" {
+		abort()
+		return 0, fmt.Errorf("s3: complete multipart upload: %s %s", s3Err.Code, s3Err.Message)
+	}
+	return size, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.GetRange(ctx, key, 0, -1)
+}
+
+func (s *S3Store) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	header := http.Header{}
+	if length > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := s.do(ctx, http.MethodGet, key, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	resp, err := s.call(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+
+	name, _ := url.PathUnescape(resp.Header.Get("X-Amz-Meta-Filename"))
+	uploadDate, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return BlobInfo{
+		Key:        key,
+		Name:       name,
+		Size:       resp.ContentLength,
+		UploadDate: uploadDate,
+	}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	_, err := s.call(ctx, http.MethodDelete, key, nil, nil, nil)
+	return err
+}
+
+func (s *S3Store) List(ctx context.Context, fn func(BlobInfo) error) error {
+	query := url.Values{"list-type": {"2"}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := checkResponse(resp); err != nil {
+			return err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, object := range page.Contents {
+			info := BlobInfo{Key: object.Key, Size: object.Size, UploadDate: object.LastModified}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// call sends a request whose response body is not needed
+func (s *S3Store) call(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	resp, _, err := s.send(ctx, method, key, query, header, body)
+	return resp, err
+}
+
+// send is call for a body whose buffer is reused, see roundTrip for sent
+func (s *S3Store) send(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, <-chan struct{}, error) {
+	resp, sent, err := s.roundTrip(ctx, method, key, query, header, body)
+	if err != nil {
+		return nil, sent, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, sent, err
+	}
+	resp.Body.Close()
+	return resp, sent, nil
+}
+
+// checkResponse turns a non-2xx response into an error, closing its body
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	var s3Err s3Error
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&s3Err)
+	return fmt.Errorf("s3: %s %s: %s %s", resp.Request.Method, resp.Status, s3Err.Code, s3Err.Message)
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	resp, _, err := s.roundTrip(ctx, method, key, query, header, body)
+	return resp, err
+}
+
+// roundTrip is do that also returns a channel closed once the transport no
+// longer reads body. That may be after the response arrived, and a retried
+// request reads body again, so its buffer is only reused after sent.
+func (s *S3Store) roundTrip(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, <-chan struct{}, error) {
+	path := "/" + s.cfg.Bucket
+	if key != "" {
+		path += "/" + key
+	}
+	reqURL := s.cfg.Endpoint + uriEncode(path, false)
+	if len(query) > 0 {
+		reqURL += "?" + canonicalQuery(query)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, http.NoBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	var open sync.WaitGroup
+	if len(body) > 0 {
+		req.GetBody = func() (io.ReadCloser, error) {
+			open.Add(1)
+			return &requestBody{r: bytes.NewReader(body), open: &open}, nil
+		}
+		req.Body, _ = req.GetBody()
+		req.ContentLength = int64(len(body))
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, path, query, body, time.Now().UTC())
+
+	// bodies are only added while the client retries, before Do returns
+	resp, err := s.client.Do(req)
+	sent := make(chan struct{})
+	go func() {
+		open.Wait()
+		close(sent)
+	}()
+	return resp, sent, err
+}
+
+var errBodyClosed = errors.New("s3: request body read after close")
+
+// requestBody is a request body that stops reading its buffer once closed
+type requestBody struct {
+	mu     sync.Mutex
+	r      *bytes.Reader
+	closed bool
+	open   *sync.WaitGroup
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	return b.r.Read(p)
+}
+
+func (b *requestBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		b.open.Done()
+	}
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header
+func (s *S3Store) sign(req *http.Request, path string, query url.Values, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "range" || lk == "content-type" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(path, false),
+		canonicalQuery(query),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode escapes everything except unreserved characters, as SigV4 requires
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
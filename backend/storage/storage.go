@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// ErrCorrupt is wrapped by the errors of blobs whose stored data is damaged,
+// as opposed to a backend that failed to return it
+var ErrCorrupt = errors.New("blob is damaged")
+
+// BlobStore keeps file contents. The key returned by Put is what
+// Files.mongo_file_id and FileVersions.mongo_file_id store.
+type BlobStore interface {
+	Put(ctx context.Context, name string, r io.Reader) (BlobInfo, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange reads length bytes starting at offset; length < 0 reads to the end
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+type BlobInfo struct {
+	Key        string
+	Name       string
+	Size       int64
+	StoredSize int64 // bytes taken in the backend, set by the wrapping stores
+	UploadDate time.Time
+}
+
+// Lister is implemented by backends that can enumerate their blobs
+type Lister interface {
+	List(ctx context.Context, fn func(BlobInfo) error) error
+}
+
+// Checker is implemented by backends that can find damage invisible
+// through BlobStore, and repair it when that is safe
+type Checker interface {
+	Check(ctx context.Context, repair bool) ([]Problem, error)
+}
+
+type Problem struct {
+	Kind     string `json:"kind"`
+	Key      string `json:"key,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired,omitempty"`
+}
+
+// Base returns the backend under the compression and encryption wrappers
+func Base(store BlobStore) BlobStore {
+	for {
+		wrapper, ok := store.(interface{ Unwrap() BlobStore })
+		if !ok {
+			return store
+		}
+		store = wrapper.Unwrap()
+	}
+}
+
+// BaseKey returns the key the backend of Base knows a blob by
+func BaseKey(key string) string {
+	for {
+		trimmed := strings.TrimPrefix(strings.TrimPrefix(strings.TrimPrefix(key, gzipPrefix), zstdPrefix), encPrefix)
+		if trimmed == key {
+			return key
+		}
+		key = trimmed
+	}
+}
+
+// limitReadCloser closes the underlying reader after a limited read
+type limitReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func limitRange(rc io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return rc
+	}
+	return limitReadCloser{Reader: io.LimitReader(rc, length), Closer: rc}
+}
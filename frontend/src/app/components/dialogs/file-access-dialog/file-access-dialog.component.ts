@@ -50,8 +50,13 @@ export class FileAccessDialogComponent {
 
     fullAccessOptions = [
         { label: 'Нет доступа', value: null },
+        { label: 'Просмотр', value: 4 },
         { label: 'Чтение', value: 1 },
+        { label: 'Комментирование', value: 5 },
         { label: 'Редактирование', value: 2 },
+        { label: 'Управление версиями', value: 6 },
+        { label: 'Управление доступом', value: 3 },
+        { label: 'Полный доступ', value: 7 },
     ];
     
     load$!: Observable<any>;
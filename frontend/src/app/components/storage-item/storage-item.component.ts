@@ -30,7 +30,7 @@ export class StorageItemComponent {
     loadGroup$?: Observable<any>;
 
     ngOnInit(): void {
-        if (!!this.file.access_id) {
+        if (!!this.file.access) {
             this.loadUser$ = this.userService.getUserById(this.file.owner_id!).pipe(
                 tap((user) => this.user = user)
             );
@@ -69,10 +69,16 @@ export class StorageItemComponent {
     }
 
     getAccess(): string {
-        if (!this.file.access_id) return ''
-        
-        if (this.file.access_id === 1) return 'чтение'
-        return 'редактирование'
+        switch (this.file.access) {
+            case 'view': return 'просмотр'
+            case 'download': return 'чтение'
+            case 'comment': return 'комментирование'
+            case 'edit': return 'редактирование'
+            case 'manage_versions': return 'управление версиями'
+            case 'reshare': return 'управление доступом'
+            case 'full_control': return 'полный доступ'
+            default: return ''
+        }
     }
 
     getAuthor(): string {
@@ -0,0 +1,17 @@
+export interface AccessGrant {
+    user_id?: number;
+    group_id?: number;
+    access_id: number | null;
+    access?: string;
+    expires_at: string | null;
+    source: 'file' | 'folder';
+    folder_id?: number;
+    folder_path?: string;
+    overridden: boolean;
+}
+
+export interface AccessLevel {
+    access_id: number;
+    name: string;
+    level: number;
+}
@@ -3,10 +3,22 @@ export interface FileMetadata {
     name: string;
     type: string;
     full_path: string;
+    folder_id?: number | null;
     create_date: string;
     edit_date: string;
     version_id: number;
     group_ids?: number[];
     owner_id?: number;
-    access_id?: number;
+    access?: string;
+    sha256?: string | null;
+    size?: number | null;
+    stored_size?: number | null;
+    compression_ratio?: number | null;
+    verified_at?: string | null;
+    corrupted?: boolean;
+}
+
+export interface TrashedFile extends FileMetadata {
+    deleted_at: string;
+    purge_at: string | null;
 }
\ No newline at end of file
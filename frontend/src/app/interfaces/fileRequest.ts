@@ -0,0 +1,21 @@
+export interface FileRequest {
+    request_id: number;
+    token: string;
+    folder_id: number;
+    created_by: number;
+    title: string;
+    max_file_size: number | null;
+    allowed_types: string[];
+    deadline: string | null;
+    revoked_at: string | null;
+    create_date: string;
+}
+
+export interface FileRequestUpload {
+    file_id: number;
+    name: string;
+    size: number | null;
+    uploader_name: string;
+    uploader_mail: string;
+    create_date: string;
+}
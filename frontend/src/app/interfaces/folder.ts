@@ -0,0 +1,17 @@
+import { FileMetadata } from './fileData';
+
+export interface Folder {
+    folder_id: number;
+    owner_id: number;
+    parent_id: number | null;
+    name: string;
+    full_path: string;
+    create_date: string;
+    edit_date: string;
+    children?: Folder[];
+}
+
+export interface FolderChildren {
+    folders: Folder[];
+    files: FileMetadata[];
+}
\ No newline at end of file
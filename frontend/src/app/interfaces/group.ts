@@ -3,6 +3,8 @@ export interface Group {
     name: string;
     description: string;
     parent_id?: number | null;
+    quota_bytes?: number | null;
+    quota_files?: number | null;
     depth: number;
     children?: Group[];
 }
\ No newline at end of file
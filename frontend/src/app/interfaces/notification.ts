@@ -0,0 +1,9 @@
+export interface Notification {
+    notification_id: number;
+    type: string;
+    message: string;
+    file_id: number | null;
+    folder_id: number | null;
+    create_date: string;
+    read_at: string | null;
+}
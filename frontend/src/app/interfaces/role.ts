@@ -2,5 +2,6 @@ export interface Role {
     role_id: number;
     name: string;
     description: string;
+    max_upload_size?: number | null;
     permissions: number[];
 }
\ No newline at end of file
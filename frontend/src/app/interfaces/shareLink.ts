@@ -0,0 +1,25 @@
+export interface ShareLink {
+    link_id: number;
+    token: string;
+    file_id: number | null;
+    folder_id: number | null;
+    created_by: number;
+    has_password: boolean;
+    mode: 'view' | 'download';
+    expires_at: string | null;
+    max_downloads: number | null;
+    download_count: number;
+    revoked_at: string | null;
+    create_date: string;
+}
+
+export interface PublicLink {
+    type: 'file' | 'folder';
+    name: string;
+    mode: 'view' | 'download';
+    size?: number | null;
+    expires_at: string | null;
+    downloads_left: number | null;
+    folders?: { folder_id: number; name: string }[];
+    files?: { file_id: number; name: string; type: string; size: number | null; edit_date: string }[];
+}
@@ -7,4 +7,6 @@ export interface User {
     type: string | null;
     role_id: number | null;
     group_id: number | null;
+    quota_bytes?: number | null;
+    quota_files?: number | null;
 }
@@ -4,5 +4,11 @@ export interface FileVersion {
     create_date: Date;
     edit_date: Date;
     is_current: boolean;
+    sha256?: string | null;
+    size?: number | null;
+    stored_size?: number | null;
+    compression_ratio?: number | null;
+    verified_at?: string | null;
+    corrupted?: boolean;
 }
   
\ No newline at end of file
@@ -1,3 +1,5 @@
+-- runs only on a fresh database, upgrade an existing one with migrate.sql
+
 CREATE TABLE Permissions (
     permission_id SERIAL PRIMARY KEY,
     name VARCHAR(100),
@@ -7,7 +9,8 @@ CREATE TABLE Permissions (
 CREATE TABLE Roles (
     role_id SERIAL PRIMARY KEY,
     name VARCHAR(100),
-    description TEXT
+    description TEXT,
+    max_upload_size BIGINT -- bytes, NULL uses MAX_UPLOAD_SIZE, 0 is unlimited
 );
 
 CREATE TABLE Role_Permissions (
@@ -19,12 +22,15 @@ CREATE TABLE Role_Permissions (
 CREATE TABLE Groups (
     group_id SERIAL PRIMARY KEY,
     name VARCHAR(100),
-    description TEXT
+    description TEXT,
+    quota_bytes BIGINT, -- for the group and its subgroups, NULL or 0 is unlimited
+    quota_files BIGINT
 );
 
 CREATE TABLE Access (
     access_id SERIAL PRIMARY KEY,
-    name VARCHAR(100)
+    name VARCHAR(100),
+    level INTEGER NOT NULL UNIQUE -- a level includes the capabilities of the lower ones
 );
 
 CREATE TABLE Users (
@@ -36,19 +42,38 @@ CREATE TABLE Users (
     password VARCHAR(100),
     name VARCHAR(100),
     surname VARCHAR(100),
-    type VARCHAR(100)
+    type VARCHAR(100),
+    quota_bytes BIGINT, -- NULL or 0 is unlimited
+    quota_files BIGINT
 );
 
+CREATE TABLE Folders (
+    folder_id SERIAL PRIMARY KEY,
+    owner_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    parent_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE, -- NULL at the top level
+    name VARCHAR(255) NOT NULL,
+    full_path VARCHAR(255) NOT NULL, -- names from the top, e.g. /projects/2024
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    edit_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX folders_name ON Folders (owner_id, COALESCE(parent_id, 0), name);
+
 CREATE TABLE Files (
     file_id SERIAL PRIMARY KEY,
-    mongo_file_id TEXT,
+    mongo_file_id TEXT, -- blob key in the configured storage backend
+    sha256 CHAR(64), -- of the content as uploaded
+    size BIGINT,
     owner_id INTEGER REFERENCES Users(user_id),
     version_id INTEGER,
     type VARCHAR(50),
     name VARCHAR(100),
     full_path VARCHAR(255),
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE SET NULL, -- NULL at the top level
     create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    edit_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    edit_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    access_date TIMESTAMP, -- last download
+    deleted_at TIMESTAMP -- moved to trash, NULL if not
 );
 
 CREATE TABLE FileVersions (
@@ -58,7 +83,9 @@ CREATE TABLE FileVersions (
     name VARCHAR(100),
     create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     edit_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    mongo_file_id TEXT,
+    mongo_file_id TEXT, -- blob key in the configured storage backend
+    sha256 CHAR(64), -- of the content as uploaded
+    size BIGINT,
     UNIQUE(file_id, name)
 );
 
@@ -82,17 +109,122 @@ ON DELETE SET NULL;
 CREATE TABLE File_Users (
     file_id INTEGER REFERENCES Files(file_id) ON DELETE CASCADE,
     user_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
-    access_id INTEGER REFERENCES Access(access_id),
+    access_id INTEGER REFERENCES Access(access_id), -- NULL removes access inherited from folders
+    expires_at TIMESTAMP, -- NULL never expires
     PRIMARY KEY (file_id, user_id)
 );
 
 CREATE TABLE File_Groups (
     file_id INTEGER REFERENCES Files(file_id) ON DELETE CASCADE,
     group_id INTEGER REFERENCES Groups(group_id) ON DELETE CASCADE,
-    access_id INTEGER REFERENCES Access(access_id),
+    access_id INTEGER REFERENCES Access(access_id), -- NULL removes access inherited from folders
+    expires_at TIMESTAMP, -- NULL never expires
     PRIMARY KEY (file_id, group_id)
 );
 
+-- access to a folder is inherited by the folders and files below it, the
+-- nearest grant to the same user or group wins
+CREATE TABLE Folder_Users (
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    access_id INTEGER REFERENCES Access(access_id), -- NULL removes access inherited from parent folders
+    expires_at TIMESTAMP, -- NULL never expires
+    PRIMARY KEY (folder_id, user_id)
+);
+
+CREATE TABLE Folder_Groups (
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    group_id INTEGER REFERENCES Groups(group_id) ON DELETE CASCADE,
+    access_id INTEGER REFERENCES Access(access_id), -- NULL removes access inherited from parent folders
+    expires_at TIMESTAMP, -- NULL never expires
+    PRIMARY KEY (folder_id, group_id)
+);
+
+CREATE TABLE Notifications (
+    notification_id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    type VARCHAR(50), -- share_expired or file_request_upload
+    message TEXT,
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE SET NULL,
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE SET NULL,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    read_at TIMESTAMP
+);
+
+-- public links to a file or folder for people without an account
+CREATE TABLE ShareLinks (
+    link_id SERIAL PRIMARY KEY,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE CASCADE, -- the file or the folder
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    created_by INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    password VARCHAR(100), -- bcrypt hash, NULL if none
+    mode VARCHAR(20) NOT NULL, -- view or download
+    expires_at TIMESTAMP, -- NULL never expires
+    max_downloads INTEGER, -- NULL is unlimited
+    download_count INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMP,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- upload only links to a folder, the uploaded files belong to the creator
+CREATE TABLE FileRequests (
+    request_id SERIAL PRIMARY KEY,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    folder_id INTEGER NOT NULL REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    created_by INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    title VARCHAR(255),
+    max_file_size BIGINT, -- bytes, NULL uses the upload limit of the creator
+    allowed_types TEXT, -- comma separated extensions, e.g. .pdf,.docx, NULL allows any
+    deadline TIMESTAMP, -- NULL never closes
+    revoked_at TIMESTAMP,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE FileRequestUploads (
+    file_id INTEGER PRIMARY KEY REFERENCES Files(file_id) ON DELETE CASCADE,
+    request_id INTEGER REFERENCES FileRequests(request_id) ON DELETE SET NULL,
+    uploader_name VARCHAR(100),
+    uploader_mail VARCHAR(255),
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE Blobs (
+    blob_key TEXT PRIMARY KEY,
+    sha256 CHAR(64) UNIQUE,
+    size BIGINT,
+    stored_size BIGINT,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    verified_at TIMESTAMP, -- last integrity scrub
+    last_attempt_at TIMESTAMP, -- last scrub, also one that could not read the blob
+    verify_error TEXT -- NULL if the last scrub read the content intact
+);
+
+CREATE TABLE PendingBlobs (
+    blob_key TEXT PRIMARY KEY, -- blob that may have no references yet or anymore
+    create_date TIMESTAMP
+);
+
+CREATE TABLE BlobKeys (
+    blob_key TEXT PRIMARY KEY, -- key of the encrypted blob, without the compression prefix
+    data_key TEXT NOT NULL, -- wrapped with the master key master_key_id
+    master_key_id VARCHAR(16) NOT NULL,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE Uploads (
+    upload_id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    upload_length BIGINT,
+    upload_offset BIGINT DEFAULT 0,
+    name VARCHAR(100),
+    full_path VARCHAR(255),
+    type VARCHAR(50),
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE SET NULL,
+    expires_at TIMESTAMP,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
 CREATE OR REPLACE FUNCTION get_group_tree(root_id INT)
 RETURNS TABLE (
     group_id INT,
@@ -125,11 +257,18 @@ $$ LANGUAGE plpgsql;
 INSERT INTO Permissions (name, description) VALUES
 ('manage_roles', 'Управление ролями'),
 ('manage_groups', 'Управление группами'),
-('manage_users', 'Управление пользователями');
+('manage_users', 'Управление пользователями'),
+('view_reports', 'Просмотр отчетов');
 
-INSERT INTO Access (name) VALUES
-('read'),
-('write');
+-- access_id 1 to 3 take over from the former read, write and manage
+INSERT INTO Access (name, level) VALUES
+('download', 20),
+('edit', 40),
+('reshare', 60),
+('view', 10),
+('comment', 30),
+('manage_versions', 50),
+('full_control', 70);
 
 INSERT INTO Users (login, password, mail, name, surname, type) 
 VALUES ('admin', '$2a$10$FMCEflfMWM0mdyj2laQLmOZ6KbpVH5.I62Hj7wPCzZmYWxYFbCtqG', 'admin@admin.admin', 'admin', 'admin', 'admin')
@@ -0,0 +1,174 @@
+-- brings a database created from an older init.sql up to date, safe to run
+-- more than once:
+--   docker exec -i postgresdb psql -U postgres -d filestorage < postgre/migrate.sql
+-- afterwards run the backend with migrate-folders and backfill-blobs
+
+BEGIN;
+
+ALTER TABLE Roles ADD COLUMN IF NOT EXISTS max_upload_size BIGINT;
+
+ALTER TABLE Groups ADD COLUMN IF NOT EXISTS quota_bytes BIGINT;
+ALTER TABLE Groups ADD COLUMN IF NOT EXISTS quota_files BIGINT;
+
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS quota_bytes BIGINT;
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS quota_files BIGINT;
+
+-- the former read, write and manage keep their ids, so existing grants keep
+-- their meaning
+ALTER TABLE Access ADD COLUMN IF NOT EXISTS level INTEGER;
+UPDATE Access SET name = 'download', level = 20 WHERE name = 'read';
+UPDATE Access SET name = 'edit', level = 40 WHERE name = 'write';
+UPDATE Access SET name = 'reshare', level = 60 WHERE name = 'manage';
+INSERT INTO Access (name, level)
+SELECT v.name, v.level
+FROM (VALUES
+    (1, 'download', 20),
+    (2, 'edit', 40),
+    (3, 'reshare', 60),
+    (4, 'view', 10),
+    (5, 'comment', 30),
+    (6, 'manage_versions', 50),
+    (7, 'full_control', 70)
+) AS v(ord, name, level)
+WHERE NOT EXISTS (SELECT 1 FROM Access a WHERE a.level = v.level)
+ORDER BY v.ord;
+-- fails if Access has rows of its own, give them a level first
+ALTER TABLE Access ALTER COLUMN level SET NOT NULL;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'access_level_key') THEN
+        ALTER TABLE Access ADD CONSTRAINT access_level_key UNIQUE (level);
+    END IF;
+END;
+$$;
+
+CREATE TABLE IF NOT EXISTS Folders (
+    folder_id SERIAL PRIMARY KEY,
+    owner_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    parent_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE, -- NULL at the top level
+    name VARCHAR(255) NOT NULL,
+    full_path VARCHAR(255) NOT NULL, -- names from the top, e.g. /projects/2024
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    edit_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS folders_name ON Folders (owner_id, COALESCE(parent_id, 0), name);
+
+ALTER TABLE Files ADD COLUMN IF NOT EXISTS sha256 CHAR(64);
+ALTER TABLE Files ADD COLUMN IF NOT EXISTS size BIGINT;
+ALTER TABLE Files ADD COLUMN IF NOT EXISTS folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE SET NULL;
+ALTER TABLE Files ADD COLUMN IF NOT EXISTS access_date TIMESTAMP;
+ALTER TABLE Files ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+ALTER TABLE FileVersions ADD COLUMN IF NOT EXISTS sha256 CHAR(64);
+ALTER TABLE FileVersions ADD COLUMN IF NOT EXISTS size BIGINT;
+
+ALTER TABLE File_Users ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE File_Groups ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS Folder_Users (
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    access_id INTEGER REFERENCES Access(access_id), -- NULL removes access inherited from parent folders
+    expires_at TIMESTAMP, -- NULL never expires
+    PRIMARY KEY (folder_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS Folder_Groups (
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    group_id INTEGER REFERENCES Groups(group_id) ON DELETE CASCADE,
+    access_id INTEGER REFERENCES Access(access_id), -- NULL removes access inherited from parent folders
+    expires_at TIMESTAMP, -- NULL never expires
+    PRIMARY KEY (folder_id, group_id)
+);
+
+CREATE TABLE IF NOT EXISTS Notifications (
+    notification_id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    type VARCHAR(50), -- share_expired or file_request_upload
+    message TEXT,
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE SET NULL,
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE SET NULL,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    read_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ShareLinks (
+    link_id SERIAL PRIMARY KEY,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE CASCADE, -- the file or the folder
+    folder_id INTEGER REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    created_by INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    password VARCHAR(100), -- bcrypt hash, NULL if none
+    mode VARCHAR(20) NOT NULL, -- view or download
+    expires_at TIMESTAMP, -- NULL never expires
+    max_downloads INTEGER, -- NULL is unlimited
+    download_count INTEGER NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMP,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS FileRequests (
+    request_id SERIAL PRIMARY KEY,
+    token VARCHAR(64) NOT NULL UNIQUE,
+    folder_id INTEGER NOT NULL REFERENCES Folders(folder_id) ON DELETE CASCADE,
+    created_by INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    title VARCHAR(255),
+    max_file_size BIGINT, -- bytes, NULL uses the upload limit of the creator
+    allowed_types TEXT, -- comma separated extensions, e.g. .pdf,.docx, NULL allows any
+    deadline TIMESTAMP, -- NULL never closes
+    revoked_at TIMESTAMP,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS FileRequestUploads (
+    file_id INTEGER PRIMARY KEY REFERENCES Files(file_id) ON DELETE CASCADE,
+    request_id INTEGER REFERENCES FileRequests(request_id) ON DELETE SET NULL,
+    uploader_name VARCHAR(100),
+    uploader_mail VARCHAR(255),
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS Blobs (
+    blob_key TEXT PRIMARY KEY,
+    sha256 CHAR(64) UNIQUE,
+    size BIGINT,
+    stored_size BIGINT,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    verified_at TIMESTAMP, -- last integrity scrub
+    last_attempt_at TIMESTAMP, -- last scrub, also one that could not read the blob
+    verify_error TEXT -- NULL if the last scrub read the content intact
+);
+
+ALTER TABLE Blobs ADD COLUMN IF NOT EXISTS last_attempt_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS PendingBlobs (
+    blob_key TEXT PRIMARY KEY, -- blob that may have no references yet or anymore
+    create_date TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS BlobKeys (
+    blob_key TEXT PRIMARY KEY, -- key of the encrypted blob, without the compression prefix
+    data_key TEXT NOT NULL, -- wrapped with the master key master_key_id
+    master_key_id VARCHAR(16) NOT NULL,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS Uploads (
+    upload_id VARCHAR(64) PRIMARY KEY,
+    user_id INTEGER REFERENCES Users(user_id) ON DELETE CASCADE,
+    upload_length BIGINT,
+    upload_offset BIGINT DEFAULT 0,
+    name VARCHAR(100),
+    full_path VARCHAR(255),
+    type VARCHAR(50),
+    file_id INTEGER REFERENCES Files(file_id) ON DELETE SET NULL,
+    expires_at TIMESTAMP,
+    create_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO Permissions (name, description)
+SELECT 'view_reports', 'Просмотр отчетов'
+WHERE NOT EXISTS (SELECT 1 FROM Permissions WHERE name = 'view_reports');
+
+COMMIT;